@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -44,8 +45,8 @@ var mapTiffFormatsTest = map[string]*TagFormat{
 	"SLong":     newTagFormat(FormatInt32, "FormatInt32", "Long int32", 4),
 	"SRational": newTagFormat(FormatRational, "FormatRational", "n/d Rational", 8),
 	"SShort":    newTagFormat(FormatInt16, "FormatInt16", "Short int16", 2),
-	"Float":     newTagFormat(FormatFloat32, "FormatFloat32", "Single Float32", 2),
-	"Double":    newTagFormat(FormatFloat64, "FormatFloat64", "Double Float64", 4),
+	"Float":     newTagFormat(FormatFloat32, "FormatFloat32", "Single Float32", 4),
+	"Double":    newTagFormat(FormatFloat64, "FormatFloat64", "Double Float64", 8),
 }
 
 func main() {
@@ -160,7 +161,14 @@ func main() {
 	mapTiffFormatsGenerated.WriteString(fmt.Sprintf("  %s: {tiffFormat:%s, formatName:\"%s\", desc:\"%s\", byteLen:%d},// Added. There is no spec for: SByte\n", v.formatName, v.formatName, v.formatName, v.desc, v.byteLen))
 	mapTiffFormatsGenerated.WriteString("}\n")
 
-	for k, buff := range mapTreeBuff {
+	// Sorted so the generated file only changes when the data does
+	groupNames := make([]string, 0, len(mapTreeBuff))
+	for k := range mapTreeBuff {
+		groupNames = append(groupNames, k)
+	}
+	sort.Strings(groupNames)
+	for _, k := range groupNames {
+		buff := mapTreeBuff[k]
 		mapTagsGenerated.WriteString(fmt.Sprintf("\"%s\": { // Group!\n", k))
 		mapTagsGenerated.WriteString(buff.String())
 		mapTagsGenerated.WriteString("},\n")
@@ -7,6 +7,7 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -202,11 +203,27 @@ func (p *UserPathInfo) Path() string {
 type ThumbnailInfo struct {
 	ThumbNailsExec       []string
 	ThumbNailsExecFile   string
+	ThumbNailsExecQuote  bool
 	ThumbNailTimeStamp   string
+	UtcTimeStamps        bool
 	ThumbNailFileSuffix  string
 	ThumbNailsRoot       string
 	ImageExtensions      []string
 	ThumbNailsMaxPerFile int
+	ThumbNailSize        int
+	ThumbNailQuality     int
+	FastThumbNails       bool
+	FastThumbNailMinSize int
+	RawPreviews          bool
+	Renderer             string
+	Workers              int
+	ExecShell            []string
+	StateDir             string
+	CheckStale           bool
+	XmpSidecars          string
+	IptcDates            bool
+	GpsDates             bool
+	PreloadThumbNails    bool
 	Verbose              bool
 	Resources            map[string]*Users
 	LogPath              string
@@ -230,11 +247,27 @@ func NewThumbnailInfo(content []byte, configFileName string, verboseArg bool) *T
 	thumbnailInfo := &ThumbnailInfo{
 		ThumbNailsExec:       []string{"Undefined"},
 		ThumbNailsExecFile:   "",
+		ThumbNailsExecQuote:  false,
 		ThumbNailsRoot:       "",
 		ThumbNailTimeStamp:   "%y_%m_%d_%H_%M_%S_",
+		UtcTimeStamps:        false,
 		ThumbNailFileSuffix:  ".json",
 		ImageExtensions:      make([]string, 0),
 		ThumbNailsMaxPerFile: math.MaxInt,
+		ThumbNailSize:        200,
+		ThumbNailQuality:     85,
+		FastThumbNails:       false,
+		FastThumbNailMinSize: -1, // Not set. ThumbNailSize is used
+		RawPreviews:          false,
+		Renderer:             RendererScript,
+		Workers:              runtime.NumCPU(),
+		ExecShell:            []string{"/bin/bash", "-c"},
+		StateDir:             "",
+		CheckStale:           false,
+		XmpSidecars:          XmpSidecarAfter,
+		IptcDates:            false,
+		GpsDates:             false,
+		PreloadThumbNails:    false,
 		Verbose:              false,
 		Resources:            make(map[string]*Users),
 		LogPath:              "",
@@ -258,6 +291,11 @@ func NewThumbnailInfo(content []byte, configFileName string, verboseArg bool) *T
 	fileExists(thumbnailInfo.ThumbNailsRoot, "ThumbNailsRoot", true, 1)
 	thumbnailInfo.ThumbNailsRoot = absPath(thumbnailInfo.ThumbNailsRoot)
 
+	if thumbnailInfo.StateDir != "" {
+		fileExists(thumbnailInfo.StateDir, "StateDir", true, 1)
+		thumbnailInfo.StateDir = absPath(thumbnailInfo.StateDir)
+	}
+
 	if thumbnailInfo.LogName != "" {
 		fileExists(thumbnailInfo.LogPath, "LogPath", true, 1)
 		thumbnailInfo.LogPath = absPath(thumbnailInfo.LogPath)
@@ -269,14 +307,55 @@ func NewThumbnailInfo(content []byte, configFileName string, verboseArg bool) *T
 		thumbnailInfo.logger = newLogfile("", "", false)
 	}
 
-	if thumbnailInfo.ThumbNailsExecFile == "" {
-		os.Stdout.WriteString(fmt.Sprintf("thumbNailsExecFile is not defined in: %s\n", configFileName))
+	switch thumbnailInfo.Renderer {
+	case RendererScript:
+		if thumbnailInfo.ThumbNailsExecFile == "" {
+			os.Stdout.WriteString(fmt.Sprintf("thumbNailsExecFile is not defined in: %s\n", configFileName))
+			os.Exit(1)
+		}
+
+		thumbnailInfo.ThumbNailsExecFile, err = filepath.Abs(thumbnailInfo.ThumbNailsExecFile)
+		if err != nil {
+			os.Stdout.WriteString(fmt.Sprintf("thumbNailsExecFile is invalid in: %s. Error: %s\n", configFileName, err.Error()))
+			os.Exit(1)
+		}
+	case RendererExec:
+		if len(thumbnailInfo.ExecShell) == 0 {
+			os.Stdout.WriteString(fmt.Sprintf("execShell is not defined in: %s\n", configFileName))
+			os.Exit(1)
+		}
+		if thumbnailInfo.Workers <= 0 {
+			thumbnailInfo.Workers = runtime.NumCPU()
+		}
+	case RendererNative:
+		if thumbnailInfo.ThumbNailSize <= 0 {
+			os.Stdout.WriteString(fmt.Sprintf("thumbNailSize must be greater than 0 in: %s\n", configFileName))
+			os.Exit(1)
+		}
+		if thumbnailInfo.ThumbNailQuality < 1 || thumbnailInfo.ThumbNailQuality > 100 {
+			os.Stdout.WriteString(fmt.Sprintf("thumbNailQuality must be 1..100 in: %s\n", configFileName))
+			os.Exit(1)
+		}
+		// A smaller EXIF thumbnail would be written as it is, as thumbnails are never enlarged
+		if thumbnailInfo.FastThumbNailMinSize == -1 {
+			thumbnailInfo.FastThumbNailMinSize = thumbnailInfo.ThumbNailSize
+		}
+		if thumbnailInfo.FastThumbNailMinSize < 0 {
+			os.Stdout.WriteString(fmt.Sprintf("fastThumbNailMinSize must not be negative in: %s\n", configFileName))
+			os.Exit(1)
+		}
+		if thumbnailInfo.Workers <= 0 {
+			thumbnailInfo.Workers = runtime.NumCPU()
+		}
+	default:
+		os.Stdout.WriteString(fmt.Sprintf("renderer '%s' is invalid in: %s. Use '%s', '%s' or '%s'\n", thumbnailInfo.Renderer, configFileName, RendererScript, RendererExec, RendererNative))
 		os.Exit(1)
 	}
 
-	thumbnailInfo.ThumbNailsExecFile, err = filepath.Abs(thumbnailInfo.ThumbNailsExecFile)
-	if err != nil {
-		os.Stdout.WriteString(fmt.Sprintf("thumbNailsExecFile is invalid in: %s. Error: %s\n", configFileName, err.Error()))
+	switch thumbnailInfo.XmpSidecars {
+	case XmpSidecarAfter, XmpSidecarBefore, XmpSidecarIgnore:
+	default:
+		os.Stdout.WriteString(fmt.Sprintf("xmpSidecars '%s' is invalid in: %s. Use '%s', '%s' or '%s'\n", thumbnailInfo.XmpSidecars, configFileName, XmpSidecarAfter, XmpSidecarBefore, XmpSidecarIgnore))
 		os.Exit(1)
 	}
 
@@ -307,8 +386,26 @@ func (tni *ThumbnailInfo) Next() *UserPathInfo {
 }
 
 func (tni *ThumbnailInfo) ExampleTimeStamp() string {
-	fdt := NewFileDateTimeFromTime(time.Now())
-	return fdt.Format(tni.ThumbNailTimeStamp)
+	return tni.TimeStamp(NewFileDateTimeFromTime(time.Now()))
+}
+
+/*
+The settings that change which date GetFileDateTime derives for an image. Kept with each scan index
+entry so the index is not used after they change.
+*/
+func (tni *ThumbnailInfo) DateTiers() string {
+	return fmt.Sprintf("xmpSidecars=%s,iptcDates=%t,gpsDates=%t", tni.XmpSidecars, tni.IptcDates, tni.GpsDates)
+}
+
+/*
+The thumbnail time stamp for dt using ThumbNailTimeStamp. The time is converted to UTC first if UtcTimeStamps
+is true so images from cameras and phones in different time zones sort together.
+*/
+func (tni *ThumbnailInfo) TimeStamp(dt *FileDateTime) string {
+	if tni.UtcTimeStamps {
+		dt = dt.UTC()
+	}
+	return dt.Format(tni.ThumbNailTimeStamp)
 }
 
 func (tni *ThumbnailInfo) Extensions() []string {
@@ -340,14 +437,44 @@ func (tni *ThumbnailInfo) String() string {
 	}
 	buff.WriteString(" --> ")
 	buff.WriteString(tni.ThumbNailsRoot)
+	buff.WriteString("\n ## ThumbNailsExecQuote:  ")
+	buff.WriteString(fmt.Sprintf("%t", tni.ThumbNailsExecQuote))
 	buff.WriteString("\n ## ThumbNailTimeStamp:   ")
 	buff.WriteString(tni.ThumbNailTimeStamp)
 	buff.WriteString(" Example:")
 	buff.WriteString(tni.ExampleTimeStamp())
+	buff.WriteString("\n ## UtcTimeStamps:        ")
+	buff.WriteString(fmt.Sprintf("%t", tni.UtcTimeStamps))
 	buff.WriteString("\n ## ThumbNailFileSuffix:  ")
 	buff.WriteString(tni.ThumbNailFileSuffix)
 	buff.WriteString("\n ## ThumbNailsMaxPerFile: ")
 	buff.WriteString(strconv.Itoa(tni.ThumbNailsMaxPerFile))
+	buff.WriteString("\n ## Renderer:             ")
+	buff.WriteString(tni.Renderer)
+	buff.WriteString("\n ## ThumbNailSize:        ")
+	buff.WriteString(strconv.Itoa(tni.ThumbNailSize))
+	buff.WriteString("\n ## ThumbNailQuality:     ")
+	buff.WriteString(strconv.Itoa(tni.ThumbNailQuality))
+	buff.WriteString("\n ## FastThumbNails:       ")
+	buff.WriteString(fmt.Sprintf("%t (min %d)", tni.FastThumbNails, tni.FastThumbNailMinSize))
+	buff.WriteString("\n ## RawPreviews:          ")
+	buff.WriteString(fmt.Sprintf("%t", tni.RawPreviews))
+	buff.WriteString("\n ## Workers:              ")
+	buff.WriteString(strconv.Itoa(tni.Workers))
+	buff.WriteString("\n ## ExecShell:            ")
+	buff.WriteString(strings.Join(tni.ExecShell, " "))
+	buff.WriteString("\n ## StateDir:             ")
+	buff.WriteString(tni.StateDir)
+	buff.WriteString("\n ## CheckStale:           ")
+	buff.WriteString(fmt.Sprintf("%t", tni.CheckStale))
+	buff.WriteString("\n ## XmpSidecars:          ")
+	buff.WriteString(tni.XmpSidecars)
+	buff.WriteString("\n ## IptcDates:            ")
+	buff.WriteString(fmt.Sprintf("%t", tni.IptcDates))
+	buff.WriteString("\n ## GpsDates:             ")
+	buff.WriteString(fmt.Sprintf("%t", tni.GpsDates))
+	buff.WriteString("\n ## PreloadThumbNails:    ")
+	buff.WriteString(fmt.Sprintf("%t", tni.PreloadThumbNails))
 	buff.WriteString("\n ## Verbose:              ")
 	buff.WriteString(fmt.Sprintf("%t", tni.Verbose))
 	buff.WriteString("\n ## Log:                  ")
@@ -166,6 +166,9 @@ type Data struct {
 	fileName     string // The file name
 	tnExists     bool
 	tnCreateDone bool
+	staleTn      string      // An out of date thumbnail to be replaced. Empty if none
+	sidecar      string      // The XMP sidecar file name (in the same dir). Empty if none
+	exec         *ExecStatus // Result of running ThumbNailsExec directly. nil if not run
 	err          error
 }
 
@@ -199,10 +202,13 @@ type Dict struct {
 
 	list           []*Data             // List of images to be processed
 	groups         map[GroupKey]*Group // root,user, and path to reduce duplication in Data
-	fileCache      *fileCache          // list of files in current dir. Speed up thumbnail check.
+	tnCache        *thumbNailCache     // Listings of the thumbnail dirs. Speed up thumbnail check.
+	index          *ScanIndex          // Results from previous runs. nil if no StateDir is configured
 	createdDirs    map[string]bool     // List of create dir paths. To stop multiple create generation
 	fileCount      int                 // Number of files found
 	tnMissingCount int                 // Number of files without thumbnails
+	tnStaleCount   int                 // Number of files with out of date thumbnails
+	sidecars       map[string]string   // Image path to XMP sidecar path. Found by scanUserPath
 }
 
 func NewDict(config *ThumbnailInfo) *Dict {
@@ -212,17 +218,38 @@ func NewDict(config *ThumbnailInfo) *Dict {
 		tnSuffixLen: len(config.ThumbNailFileSuffix),
 		tnPrefixLen: len(config.ExampleTimeStamp()),
 	}
+	if config.StateDir != "" {
+		index, err := LoadScanIndex(config.StateDir, config.DateTiers())
+		if err != nil {
+			config.logger.Log(fmt.Sprintf("File:%s Error:%s", index.path, err.Error()), "Scan index could not be read:")
+		} else if config.Verbose {
+			config.logger.Log(fmt.Sprintf("File:%s Entries:%d", index.path, index.Len()), "Scan index loaded:")
+		}
+		if index.Discarded() > 0 {
+			config.logger.Log(fmt.Sprintf("File:%s Entries:%d. The date settings have changed so the images will be dated again", index.path, index.Discarded()), "Scan index entries discarded:")
+		}
+		d.index = index
+	}
 	d.reset()
 	return d
 }
 
+/*
+Save the scan index (if there is one) so the next run can skip unchanged files.
+*/
+func (d *Dict) SaveIndex() error {
+	return d.index.Save()
+}
+
 func (dict *Dict) reset() {
 	dict.groups = map[GroupKey]*Group{}
 	dict.list = []*Data{}
-	dict.fileCache = CleanFileCache()
+	dict.tnCache = NewThumbNailCache(dict.tnPrefixLen, dict.tnSuffixLen)
 	dict.createdDirs = map[string]bool{}
 	dict.fileCount = 0
 	dict.tnMissingCount = 0
+	dict.tnStaleCount = 0
+	dict.sidecars = map[string]string{}
 }
 
 func (tni *Dict) LogLine(s string, prefix string) {
@@ -233,11 +260,19 @@ func (tni *Dict) LogLine(s string, prefix string) {
 
 func (dict *Dict) Populate(timer *TimedProcess, verbose bool) {
 	dict.reset()
+	if dict.config.PreloadThumbNails {
+		err := dict.tnCache.Preload(dict.tnPath)
+		if err != nil {
+			config.logger.Log(fmt.Sprintf("Path:%s Error:%s", dict.tnPath, err.Error()), "Thumbnail preload failed:")
+			dict.tnCache = NewThumbNailCache(dict.tnPrefixLen, dict.tnSuffixLen)
+		}
+	}
 
 	extensions := config.Extensions()
+	findSidecars := dict.config.XmpSidecars != XmpSidecarIgnore
 	path := dict.config.Next()
 	for path != nil {
-		scanUserPath(path,
+		err := scanUserPath(path, findSidecars,
 			func(name string) bool {
 				// shouldIncludeFile
 				if len(config.ImageExtensions) == 0 {
@@ -252,8 +287,22 @@ func (dict *Dict) Populate(timer *TimedProcess, verbose bool) {
 			}, // OnFound
 			func(d *Data) {
 				if d.err == nil {
+					dict.index.Seen(filepath.Join(d.groupData.root, d.groupData.user, d.groupData.source, d.fileName))
+					if d.sidecar != "" {
+						dict.sidecars[filepath.Join(d.groupData.root, d.groupData.user, d.groupData.source, d.fileName)] = filepath.Join(d.groupData.root, d.groupData.user, d.groupData.source, d.sidecar)
+					}
 					dict.fileCount++
 					d.tnExists = dict.CheckThumbNailFile(d.fileName, d.groupData)
+					if d.tnExists && dict.config.CheckStale {
+						d.staleTn = dict.CheckStaleThumbNail(d)
+						if d.staleTn != "" {
+							d.tnExists = false
+							dict.tnStaleCount++
+							if verbose {
+								config.logger.Log(d.staleTn, "Stale thumbnail:")
+							}
+						}
+					}
 					if !d.tnExists {
 						dict.tnMissingCount++
 						d.number = dict.fileCount
@@ -271,6 +320,9 @@ func (dict *Dict) Populate(timer *TimedProcess, verbose bool) {
 					config.logger.Log(d.String(), "ERROR:")
 				}
 			})
+		if err == nil {
+			dict.index.Walked(path.Path())
+		}
 		path = dict.config.Next()
 	}
 }
@@ -290,38 +342,135 @@ func (d *Dict) Add(data *Data) {
 }
 
 func (d *Dict) GetFileTimeStamp(fileName string, g *Group, logLineFunc func(string, string)) string {
+	dt := d.GetFileDateTime(fileName, g, logLineFunc)
+	if dt != nil {
+		return d.config.TimeStamp(dt)
+	}
+	return ""
+}
+
+// The suffix of the OffsetTime and SubSecTime tags that go with each EXIF date
+var exifTimeTagSuffix = map[int]string{
+	SrcDateTimeOriginal:  "Original",
+	SrcDateTime:          "",
+	SrcDateTimeDigitized: "Digitized",
+}
+
+/*
+Derive the date and time for an image. In order of preference:
+
+	The scan index if the file (and its XMP sidecar) and the date settings are unchanged since it was last read.
+	The XMP sidecar date if XmpSidecars is 'before'.
+	The GPS time if GpsDates is true. In the UTC offset of the EXIF date or, if that is not known, local time.
+	EXIF DateTimeOriginal, DateTime or DateTimeDigitized with the matching OffsetTime and SubSecTime.
+	If there is no OffsetTime the offset is found from the GPS time.
+	The PNG creation time text or the video (MP4, MOV, 3GP) creation time.
+	XMP exif:DateTimeOriginal, photoshop:DateCreated or xmp:CreateDate.
+	IPTC DateCreated and TimeCreated if IptcDates is true.
+	The XMP sidecar date if XmpSidecars is 'after' (the default).
+	A date in the file name.
+	The file modification time.
+*/
+func (d *Dict) GetFileDateTime(fileName string, g *Group, logLineFunc func(string, string)) *FileDateTime {
 	var dt *FileDateTime
 	imagePath := filepath.Join(g.root, g.user, g.source, fileName)
 	stat, err := os.Stat(imagePath)
 	if err == nil && stat != nil {
-		_, err := NewImage(imagePath, false, func(i *IFDEntry, w *Walker) bool {
-			if i != nil {
-				if i.TagData.Name == "DateTimeOriginal" && dt == nil {
-					dt, _ = NewFileDateTimeFromSpec(i.Value, 1)
+		// The later of the image and sidecar times is indexed so an edited sidecar is read again
+		modTime := stat.ModTime()
+		sidecarPath := d.sidecars[imagePath]
+		if sidecarPath != "" {
+			sidecarStat, err := os.Stat(sidecarPath)
+			if err == nil && sidecarStat.ModTime().After(modTime) {
+				modTime = sidecarStat.ModTime()
+			}
+		}
+		e := d.index.Lookup(imagePath, stat.Size(), modTime)
+		if e != nil {
+			dt = e.FileDateTime()
+			if dt != nil {
+				return dt
+			}
+		}
+		var sidecarDt *FileDateTime
+		if sidecarPath != "" {
+			sidecar, err := ReadXmpSidecar(sidecarPath)
+			if err != nil {
+				logLineFunc(err.Error(), "")
+			}
+			if sidecar != nil {
+				sidecarDt = sidecar.DateTime()
+				if d.config.Verbose {
+					logLineFunc(sidecar.String(), "XMP Sidecar:")
 				}
-				if i.TagData.Name == "DateTime" && dt == nil {
-					dt, _ = NewFileDateTimeFromSpec(i.Value, 2)
+			}
+		}
+		if d.config.XmpSidecars == XmpSidecarBefore {
+			dt = sidecarDt
+		}
+		if dt == nil {
+			exifTimes := map[string]string{} // OffsetTime and SubSecTime tags by name
+			im, err := NewImage(imagePath, false, func(i *IFDEntry, w *Walker) bool {
+				if i != nil {
+					if strings.HasPrefix(i.TagData.Name, "OffsetTime") || strings.HasPrefix(i.TagData.Name, "SubSecTime") {
+						exifTimes[i.TagData.Name] = i.String()
+					}
+					if i.TagData.Name == "DateTimeOriginal" && dt == nil {
+						dt, _ = NewFileDateTimeFromSpec(i.String(), SrcDateTimeOriginal)
+					}
+					if i.TagData.Name == "DateTime" && dt == nil {
+						dt, _ = NewFileDateTimeFromSpec(i.String(), SrcDateTime)
+					}
+					if i.TagData.Name == "DateTimeDigitized" && dt == nil {
+						dt, _ = NewFileDateTimeFromSpec(i.String(), SrcDateTimeDigitized)
+					}
 				}
-				if i.TagData.Name == "DateTimeDigitized" && dt == nil {
-					dt, _ = NewFileDateTimeFromSpec(i.Value, 3)
+				return dt != nil || (i != nil && IsGpsEntry(i))
+			}, logLineFunc)
+			if err != nil {
+				logLineFunc(err.Error(), "")
+			}
+			if dt != nil {
+				suffix := exifTimeTagSuffix[dt.src]
+				dt.setOffset(exifTimes["OffsetTime"+suffix])
+				dt.setSubSec(exifTimes["SubSecTime"+suffix])
+			}
+			if im != nil {
+				if gps := im.GPS(); gps != nil && !gps.Time.IsZero() {
+					if dt != nil && !dt.hasOffset {
+						dt.setOffsetFromUTC(gps.Time)
+					}
+					if d.config.GpsDates {
+						// The GPS time is preferred as the camera clock can be wrong. In the offset of the EXIF date
+						dt = gps.DateTime(dt)
+						if d.config.Verbose {
+							logLineFunc(gps.String(), "GPS:")
+						}
+					}
 				}
 			}
-			return dt != nil
-		}, logLineFunc)
-		if err != nil {
-			logLineFunc(err.Error(),"")
+			if dt == nil && im != nil {
+				dt = im.CreationTime()
+			}
+			if dt == nil && im != nil {
+				dt = im.XmpDateTime()
+			}
+			if dt == nil && im != nil && d.config.IptcDates {
+				dt = im.IptcDateTime()
+			}
 		}
 		if dt == nil {
-			dt, _ = NewFileDateTimeFromSpec(fileName, 4)
+			dt = sidecarDt
+		}
+		if dt == nil {
+			dt, _ = NewFileDateTimeFromSpec(fileName, SrcFileName)
 			if dt == nil {
 				dt = NewFileDateTimeFromTime(stat.ModTime())
 			}
 		}
+		d.index.Update(imagePath, stat.Size(), modTime, dt, fmt.Sprintf("%s%s%s", d.config.TimeStamp(dt), fileName, d.config.ThumbNailFileSuffix))
 	}
-	if dt != nil {
-		return dt.Format(d.config.ThumbNailTimeStamp)
-	}
-	return ""
+	return dt
 }
 
 func (d *Dict) CountRequired() int {
@@ -341,22 +490,19 @@ func (d *Dict) CreateMissingTn(timer *TimedProcess, logFn func(string,string), e
 			timer.Event()
 			ts := d.GetFileTimeStamp(data.fileName, data.groupData, logFn)
 			if ts != "" {
-				inFile := filepath.Join(data.groupData.root, data.groupData.user, data.groupData.source, data.fileName)
-				outPath := filepath.Join(dict.tnPath, data.groupData.user, data.groupData.source)
+				inFile, outPath, outFile := d.ThumbNailFiles(data, ts)
 				if !dirExists(outPath) {
 					_, ok := dict.createdDirs[outPath]
 					if !ok {
-						execOut(fmt.Sprintf("mkdir -p \"%s\"", outPath))
+						execOut(fmt.Sprintf("mkdir -p %s", ShellQuote(outPath)))
 					}
 					dict.createdDirs[outPath] = true
 				}
-				outFile := filepath.Join(outPath, fmt.Sprintf("%s%s%s", ts, data.fileName, dict.config.ThumbNailFileSuffix))
-				ex := ""
+				if data.staleTn != "" {
+					execOut(fmt.Sprintf("rm -f %s", ShellQuote(data.staleTn)))
+				}
 				for _, e := range dict.config.ThumbNailsExec {
-					ex = strings.ReplaceAll(e, "%in", inFile)
-					ex = strings.ReplaceAll(ex, "%out", outFile)
-					ex = strings.ReplaceAll(ex, "%count", padN(data.number, 7))
-					execOut(ex)
+					execOut(ExpandExec(e, inFile, outFile, data.number, dict.config.ThumbNailsExecQuote))
 				}
 				data.tnCreateDone = true
 				creates++
@@ -370,12 +516,228 @@ func (d *Dict) CreateMissingTn(timer *TimedProcess, logFn func(string,string), e
 	}
 }
 
-func (d *Dict) CheckThumbNailFile(fileName string, g *Group) bool {
-	tnPath := filepath.Join(d.tnPath, g.user, g.source)
-	if d.fileCache.path != d.tnPath {
-		d.fileCache = NewFileCache(tnPath, d.tnPrefixLen, d.tnSuffixLen)
+/*
+Render the missing thumbnails in process using the native Go renderer.
+
+	Rendering is spread over config.Workers goroutines.
+	If FastThumbNails is true the EXIF thumbnail is used, when it is at least FastThumbNailMinSize (by default
+	ThumbNailSize), instead of decoding the whole image.
+	If RawPreviews is true TIFF based RAW files are rendered from their largest embedded preview.
+	Results are handled here, on the calling goroutine, so Data state, logging and the timer are not shared.
+	Thumbnails that fail are logged and left as required.
+*/
+func (d *Dict) RenderMissingTn(timer *TimedProcess, logFn func(string, string)) {
+	required := []*Data{}
+	for _, data := range d.list {
+		if data.Required() {
+			required = append(required, data)
+		}
+	}
+	RunWorkerPool(d.config.Workers, required, func(data *Data) *WorkResult {
+		r := NewWorkResult(data)
+		ts := d.GetFileTimeStamp(data.fileName, data.groupData, r.Log)
+		if ts == "" {
+			r.err = fmt.Errorf("time stamp could not be derived. File:%s", data.fileName)
+			return r
+		}
+		r.inFile, _, r.outFile = d.ThumbNailFiles(data, ts)
+		if d.config.RawPreviews {
+			done, err := RenderLargestPreview(r.inFile, r.outFile, d.config.ThumbNailSize, d.config.ThumbNailQuality, r.Log)
+			if err != nil {
+				r.Log(fmt.Sprintf("File:%s Error:%s", r.inFile, err.Error()), "Embedded preview not used:")
+			}
+			if done && err == nil {
+				return r
+			}
+		}
+		if d.config.FastThumbNails {
+			done, err := RenderEmbeddedThumbNail(r.inFile, r.outFile, d.config.ThumbNailSize, d.config.FastThumbNailMinSize, d.config.ThumbNailQuality, r.Log)
+			if err != nil {
+				r.Log(fmt.Sprintf("File:%s Error:%s", r.inFile, err.Error()), "Embedded thumbnail not used:")
+			}
+			if done && err == nil {
+				return r
+			}
+		}
+		r.err = RenderThumbNail(r.inFile, r.outFile, d.config.ThumbNailSize, d.config.ThumbNailQuality, ImageOrientation(r.inFile, r.Log))
+		return r
+	}, func(r *WorkResult) {
+		timer.Event()
+		for _, l := range r.logs {
+			logFn(l, "")
+		}
+		if r.err != nil {
+			r.data.err = r.err
+			logFn(fmt.Sprintf("File:%s Error:%s", r.inFile, r.err.Error()), "Render Failed:")
+			return
+		}
+		r.data.tnCreateDone = true
+		d.RemoveStaleTn(r.data, r.outFile, logFn)
+		if d.config.Verbose {
+			logFn(fmt.Sprintf("%s --> %s", r.inFile, r.outFile), "Rendered:")
+		}
+	})
+}
+
+/*
+Run the ThumbNailsExec commands for each missing thumbnail directly (no script files).
+
+	The expanded lines for an image are joined and run as a single script by config.ExecShell
+	on config.Workers goroutines. The exit status, stderr and duration are kept in Data.exec.
+	An image is only counted as done if the commands exit with 0 AND the thumbnail file exists.
+*/
+func (d *Dict) ExecMissingTn(timer *TimedProcess, logFn func(string, string)) {
+	required := []*Data{}
+	for _, data := range d.list {
+		if data.Required() {
+			required = append(required, data)
+		}
+	}
+	RunWorkerPool(d.config.Workers, required, func(data *Data) *WorkResult {
+		r := NewWorkResult(data)
+		ts := d.GetFileTimeStamp(data.fileName, data.groupData, r.Log)
+		if ts == "" {
+			r.err = fmt.Errorf("time stamp could not be derived. File:%s", data.fileName)
+			return r
+		}
+		var outPath string
+		r.inFile, outPath, r.outFile = d.ThumbNailFiles(data, ts)
+		err := os.MkdirAll(outPath, 0775)
+		if err != nil {
+			r.err = err
+			return r
+		}
+		if data.staleTn != "" {
+			// Commands often skip existing files so the old one must go first
+			err = os.Remove(data.staleTn)
+			if err != nil && !os.IsNotExist(err) {
+				r.err = fmt.Errorf("failed to remove stale thumbnail %s: %s", data.staleTn, err.Error())
+				return r
+			}
+		}
+		lines := make([]string, len(d.config.ThumbNailsExec))
+		for i, e := range d.config.ThumbNailsExec {
+			lines[i] = ExpandExec(e, r.inFile, r.outFile, data.number, d.config.ThumbNailsExecQuote)
+		}
+		r.exec = RunExecCommand(d.config.ExecShell, strings.Join(lines, "\n"))
+		if !r.exec.Failed() {
+			_, err := os.Stat(r.outFile)
+			if err != nil {
+				r.err = fmt.Errorf("commands completed but the thumbnail was not created")
+			}
+		}
+		return r
+	}, func(r *WorkResult) {
+		timer.Event()
+		r.data.exec = r.exec
+		for _, l := range r.logs {
+			logFn(l, "")
+		}
+		if d.config.Verbose && r.data.exec != nil && r.data.exec.stdout != "" {
+			logFn(r.data.exec.stdout, "Exec:")
+		}
+		if r.err == nil && r.data.exec != nil && r.data.exec.Failed() {
+			r.err = fmt.Errorf("%s", r.data.exec.String())
+		}
+		if r.err != nil {
+			r.data.err = r.err
+			logFn(fmt.Sprintf("File:%s Error:%s", r.inFile, r.err.Error()), "Exec Failed:")
+			return
+		}
+		r.data.tnCreateDone = true
+		if d.config.Verbose {
+			logFn(fmt.Sprintf("%s --> %s (%dms)", r.inFile, r.outFile, r.data.exec.duration.Milliseconds()), "Exec OK:")
+		}
+	})
+}
+
+/*
+Log every image that was processed but did not get a thumbnail, with the reason, and a summary line.
+
+	Returns the number of failures.
+*/
+func (d *Dict) LogFailures(logFn func(string, string)) int {
+	processed := 0
+	created := 0
+	for _, data := range d.list {
+		if data.tnExists {
+			continue
+		}
+		processed++
+		if data.tnCreateDone {
+			created++
+			continue
+		}
+		inFile := filepath.Join(data.groupData.root, data.groupData.user, data.groupData.source, data.fileName)
+		if data.exec != nil {
+			logFn(fmt.Sprintf("File:%s %s", inFile, data.exec.String()), "FAILED:")
+		} else if data.err != nil {
+			logFn(fmt.Sprintf("File:%s Error:%s", inFile, data.err.Error()), "FAILED:")
+		} else {
+			logFn(fmt.Sprintf("File:%s", inFile), "NOT PROCESSED:")
+		}
+	}
+	logFn(fmt.Sprintf("Required: %d Created %d Failed %d", processed, created, processed-created), "")
+	return processed - created
+}
+
+/*
+Derive the source image file, the thumbnail directory and the thumbnail file for an image.
+*/
+func (d *Dict) ThumbNailFiles(data *Data, ts string) (string, string, string) {
+	inFile := filepath.Join(data.groupData.root, data.groupData.user, data.groupData.source, data.fileName)
+	outPath := filepath.Join(d.tnPath, data.groupData.user, data.groupData.source)
+	outFile := filepath.Join(outPath, fmt.Sprintf("%s%s%s", ts, data.fileName, d.config.ThumbNailFileSuffix))
+	return inFile, outPath, outFile
+}
+
+/*
+Check if the existing thumbnail for an image is out of date. Returns the thumbnail file if it is, otherwise "".
+
+	It is out of date if the source image was modified after the thumbnail was created
+	or if the timestamp prefix derived from the image is not the prefix of the thumbnail.
+*/
+func (d *Dict) CheckStaleThumbNail(data *Data) string {
+	tnName, ok := d.tnCache.Dir(filepath.Join(d.tnPath, data.groupData.user, data.groupData.source)).ThumbNail(data.fileName)
+	if !ok {
+		return ""
+	}
+	tnFile := filepath.Join(d.tnPath, data.groupData.user, data.groupData.source, tnName)
+	tnStat, err := os.Stat(tnFile)
+	if err != nil {
+		return ""
+	}
+	srcStat, err := os.Stat(filepath.Join(data.groupData.root, data.groupData.user, data.groupData.source, data.fileName))
+	if err != nil {
+		return ""
+	}
+	if srcStat.ModTime().After(tnStat.ModTime()) {
+		return tnFile
+	}
+	ts := d.GetFileTimeStamp(data.fileName, data.groupData, d.LogLine)
+	if ts != "" && !strings.HasPrefix(tnName, ts) {
+		return tnFile
+	}
+	return ""
+}
+
+/*
+Remove an out of date thumbnail once it has been replaced by outFile.
+*/
+func (d *Dict) RemoveStaleTn(data *Data, outFile string, logFn func(string, string)) {
+	if data.staleTn == "" || data.staleTn == outFile {
+		return
+	}
+	err := os.Remove(data.staleTn)
+	if err != nil && !os.IsNotExist(err) {
+		logFn(fmt.Sprintf("File:%s Error:%s", data.staleTn, err.Error()), "Failed to remove stale thumbnail:")
+	} else if d.config.Verbose {
+		logFn(data.staleTn, "Removed stale thumbnail:")
 	}
-	return d.fileCache.HasFile(fileName)
+}
+
+func (d *Dict) CheckThumbNailFile(fileName string, g *Group) bool {
+	return d.tnCache.Dir(filepath.Join(d.tnPath, g.user, g.source)).HasFile(fileName)
 }
 
 func (d *Dict) LogGroups(prefix string) {
@@ -397,10 +759,19 @@ func (d *Dict) LogDict() {
 	}
 }
 
-func scanUserPath(upi *UserPathInfo, shouldIncludeFile func(string) bool, onFound func(*Data)) {
+/*
+Walk the user path and call onFound for each file that shouldIncludeFile accepts.
+
+	If findSidecars is true XMP sidecar files are not returned as images. Instead each image
+	is given the name of its sidecar (see XmpSidecarNames) if there is one.
+	Returns the error that stopped the walk, so nil only if the whole path was walked.
+*/
+func scanUserPath(upi *UserPathInfo, findSidecars bool, shouldIncludeFile func(string) bool, onFound func(*Data)) error {
 
 	pathTrim := len(upi.root) + 1 + len(upi.user) + 1
-	filepath.WalkDir(upi.Path(), func(path string, d fs.DirEntry, err error) error {
+	sidecarDir := ""
+	sidecarNames := map[string]string{} // Lower case name to name for the sidecars in sidecarDir
+	return filepath.WalkDir(upi.Path(), func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			onFound(NewDataWithError(upi.user, upi.root, path, err))
 			return err
@@ -413,22 +784,57 @@ func scanUserPath(upi *UserPathInfo, shouldIncludeFile func(string) bool, onFoun
 		}
 		if !d.IsDir() {
 			add := true
-			if shouldIncludeFile != nil {
+			if findSidecars && strings.HasSuffix(strings.ToLower(d.Name()), XmpSidecarExt) {
+				add = false
+			}
+			if add && shouldIncludeFile != nil {
 				add = shouldIncludeFile(d.Name())
 			}
 			if add {
-				onFound(&Data{
+				data := &Data{
 					groupData: NewGroup(upi.user, upi.root, path[pathTrim:len(path)-len(d.Name())-1]),
 					fileName:  d.Name(),
 					tnExists:  false, // Will be updated by onFound Method
 					err:       nil,
-				})
+				}
+				if findSidecars {
+					dir := filepath.Dir(path)
+					if dir != sidecarDir {
+						sidecarDir = dir
+						sidecarNames = listSidecars(dir)
+					}
+					for _, n := range XmpSidecarNames(d.Name()) {
+						s, ok := sidecarNames[strings.ToLower(n)]
+						if ok {
+							data.sidecar = s
+							break
+						}
+					}
+				}
+				onFound(data)
 			}
 		}
 		return nil
 	})
 }
 
+/*
+The XMP sidecar files in dir by lower case name. Sidecar names are matched without case (.xmp or .XMP).
+*/
+func listSidecars(dir string) map[string]string {
+	names := map[string]string{}
+	raw, err := os.ReadDir(dir)
+	if err != nil {
+		return names
+	}
+	for _, fi := range raw {
+		if !fi.IsDir() && strings.HasSuffix(strings.ToLower(fi.Name()), XmpSidecarExt) {
+			names[strings.ToLower(fi.Name())] = fi.Name()
+		}
+	}
+	return names
+}
+
 func NewFileDateTimeFromSpec(spec string, src int) (*FileDateTime, error) {
 	spec1 := []byte(spec)
 	spec2 := make([]byte, 18)
@@ -491,6 +897,10 @@ func readIntFromSpec(spec []byte, from, len int) (int, int) {
 	return acc, from + len
 }
 
+/*
+The wall clock time of t in its location with the milliseconds and the UTC offset.
+*/
 func NewFileDateTimeFromTime(t time.Time) *FileDateTime {
-	return &FileDateTime{y: t.Year(), m: int(t.Month()), d: t.Day(), hh: t.Hour(), mm: t.Minute(), ss: t.Second(), src: 0}
+	_, offset := t.Zone()
+	return &FileDateTime{y: t.Year(), m: int(t.Month()), d: t.Day(), hh: t.Hour(), mm: t.Minute(), ss: t.Second(), src: SrcModTime, ms: t.Nanosecond() / int(time.Millisecond), offset: offset, hasOffset: true}
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const RendererExec = "exec"
+
+/*
+The outcome of running the expanded ThumbNailsExec commands for a single image.
+*/
+type ExecStatus struct {
+	command  string
+	exitCode int
+	stdout   string
+	stderr   string
+	duration time.Duration
+	err      error // Set if the command could not be run at all
+}
+
+func (s *ExecStatus) Failed() bool {
+	return s.err != nil || s.exitCode != 0
+}
+
+func (s *ExecStatus) String() string {
+	if s.err != nil {
+		return fmt.Sprintf("Exit[%d] Time[%dms] Error[%s] Stderr[%s]", s.exitCode, s.duration.Milliseconds(), s.err.Error(), s.stderr)
+	}
+	return fmt.Sprintf("Exit[%d] Time[%dms] Stderr[%s]", s.exitCode, s.duration.Milliseconds(), s.stderr)
+}
+
+/*
+Expand a single ThumbNailsExec line for an image.
+
+	%inq   is replaced by the source image file quoted for the shell
+	%outq  is replaced by the thumbnail file quoted for the shell
+	%in    is replaced by the source image file
+	%out   is replaced by the thumbnail file
+	%count is replaced by the image number
+
+	If quote is true %in and %out are quoted as well. Quoted values must NOT be wrapped in quotes in the template.
+	The template is expanded in a single pass so text in a file name that looks like a placeholder is never replaced.
+*/
+func ExpandExec(tmpl string, inFile string, outFile string, number int, quote bool) string {
+	in := inFile
+	out := outFile
+	if quote {
+		in = ShellQuote(inFile)
+		out = ShellQuote(outFile)
+	}
+	placeholders := []struct {
+		name  string
+		value string
+	}{
+		// Longest first so %inq is not matched as %in
+		{"%count", padN(number, 7)},
+		{"%outq", ShellQuote(outFile)},
+		{"%inq", ShellQuote(inFile)},
+		{"%out", out},
+		{"%in", in},
+	}
+	var line strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		matched := false
+		if tmpl[i] == '%' {
+			for _, p := range placeholders {
+				if strings.HasPrefix(tmpl[i:], p.name) {
+					line.WriteString(p.value)
+					i = i + len(p.name) - 1
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			line.WriteByte(tmpl[i])
+		}
+	}
+	return line.String()
+}
+
+/*
+Quote s so a POSIX shell treats it as a single literal word.
+
+	Everything is wrapped in single quotes, inside which nothing is special.
+	A single quote is written as '\'' (end quote, escaped quote, start quote).
+*/
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+/*
+Run a script using shell. The script is appended as the last argument so shell
+is normally something like ["/bin/bash", "-c"].
+*/
+func RunExecCommand(shell []string, script string) *ExecStatus {
+	status := &ExecStatus{command: script}
+	if len(shell) == 0 {
+		status.exitCode = -1
+		status.err = fmt.Errorf("exec shell is not defined")
+		return status
+	}
+	var stdout, stderr bytes.Buffer
+	args := append(append([]string{}, shell[1:]...), script)
+	cmd := exec.Command(shell[0], args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	status.duration = time.Since(start)
+	status.stdout = strings.TrimSpace(stdout.String())
+	status.stderr = strings.TrimSpace(stderr.String())
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			status.exitCode = exitErr.ExitCode()
+		} else {
+			status.exitCode = -1
+			status.err = err
+		}
+	}
+	return status
+}
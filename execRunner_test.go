@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandExec(t *testing.T) {
+	AssertEquals(t, ExpandExec("convert \"%in\" \"%out\" # %count", "/a/b.jpg", "/t/b.jpg", 12, false), "convert \"/a/b.jpg\" \"/t/b.jpg\" #      12")
+	AssertEquals(t, ExpandExec("convert %inq %outq", "/a/Owain's Phone.jpg", "/t/b.jpg", 12, false), "convert '/a/Owain'\\''s Phone.jpg' '/t/b.jpg'")
+	AssertEquals(t, ExpandExec("convert %in %out", "/a/b c.jpg", "/t/b.jpg", 12, true), "convert '/a/b c.jpg' '/t/b.jpg'")
+	// Placeholder text in a file name must not be expanded
+	AssertEquals(t, ExpandExec("cp %in %out", "/a/%out.jpg", "/t/x.jpg", 1, false), "cp /a/%out.jpg /t/x.jpg")
+}
+
+var hostileFileNames = []string{
+	"plain.jpg",
+	"StDavid'sWork/Owain's Phone.jpg",
+	"double\"quote.jpg",
+	"$HOME.jpg",
+	"${PATH}.jpg",
+	"`id`.jpg",
+	"$(id).jpg",
+	"new\nline.jpg",
+	"semi;colon & amp | pipe.jpg",
+	"back\\slash.jpg",
+	"'",
+	"''",
+	"*.jpg",
+	"-n",
+	"100%in%out.jpg",
+	"",
+}
+
+func TestShellQuoteHostileFileNames(t *testing.T) {
+	for _, fn := range hostileFileNames {
+		// printf writes the argument back exactly as the shell received it
+		for _, tmpl := range []string{"printf '%s' %inq", "printf '%s' %in"} {
+			ex := ExpandExec(tmpl, fn, "", 0, true)
+			status := RunExecCommand([]string{"/bin/sh", "-c"}, ex)
+			if status.Failed() {
+				t.Fatalf("Command [%s] failed %s", ex, status.String())
+			}
+			if status.stdout != strings.TrimSpace(fn) {
+				t.Fatalf("Round trip failed. Expected [%s] actual [%s] command [%s]", fn, status.stdout, ex)
+			}
+		}
+	}
+}
+
+func TestRunExecCommand(t *testing.T) {
+	status := RunExecCommand([]string{"/bin/sh", "-c"}, "echo out\necho err >&2\nexit 3")
+	if !status.Failed() || status.exitCode != 3 {
+		t.Fatalf("Exit code should be 3. %s", status.String())
+	}
+	AssertEquals(t, status.stdout, "out")
+	AssertEquals(t, status.stderr, "err")
+
+	status = RunExecCommand([]string{"/bin/sh", "-c"}, "true")
+	if status.Failed() {
+		t.Fatalf("Command should not fail. %s", status.String())
+	}
+
+	status = RunExecCommand([]string{"/no/such/shell", "-c"}, "true")
+	if !status.Failed() || status.err == nil || status.exitCode != -1 {
+		t.Fatalf("Missing shell should fail with an error. %s", status.String())
+	}
+}
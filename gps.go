@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const TagGPSLatitudeRef = 1
+const TagGPSLatitude = 2
+const TagGPSLongitudeRef = 3
+const TagGPSLongitude = 4
+const TagGPSAltitudeRef = 5
+const TagGPSAltitude = 6
+const TagGPSTimeStamp = 7
+const TagGPSDateStamp = 29
+
+/*
+The position and time from the GPS IFD.
+
+	Latitude and Longitude are decimal degrees. South and West are negative.
+	Altitude is in metres. Below sea level is negative.
+	Time is UTC from GPSDateStamp and GPSTimeStamp. It is zero unless both can be read.
+*/
+type Gps struct {
+	Latitude    float64
+	Longitude   float64
+	Altitude    float64
+	HasPosition bool
+	HasAltitude bool
+	Time        time.Time
+}
+
+/*
+True if the entry is in the GPS IFD. Use in a NewImage select callback to keep the entries GPS() needs.
+*/
+func IsGpsEntry(i *IFDEntry) bool {
+	return i.TagData.TagGroup == GroupNameGPS
+}
+
+/*
+The GPS data. Nil if the position and the time can not be read.
+
+	The GPS entries must have been kept by the NewImage select callback (see IsGpsEntry).
+*/
+func (p *image) GPS() *Gps {
+	entries := map[uint32]*IFDEntry{}
+	for _, i := range p.IFDdata {
+		if IsGpsEntry(i) {
+			entries[i.TagData.TagNum] = i
+		}
+	}
+	g := &Gps{}
+	lat, latOk := gpsDegrees(entries[TagGPSLatitude])
+	lon, lonOk := gpsDegrees(entries[TagGPSLongitude])
+	if latOk && lonOk {
+		g.Latitude, g.Longitude, g.HasPosition = lat, lon, true
+		if gpsRef(entries[TagGPSLatitudeRef]) == "S" {
+			g.Latitude = -g.Latitude
+		}
+		if gpsRef(entries[TagGPSLongitudeRef]) == "W" {
+			g.Longitude = -g.Longitude
+		}
+	}
+	if alt := entries[TagGPSAltitude]; alt != nil && len(alt.Rationals()) == 1 && alt.Rationals()[0].Den != 0 {
+		g.Altitude, g.HasAltitude = alt.Float(), true
+		if ref := entries[TagGPSAltitudeRef]; ref != nil && ref.Uint() == 1 {
+			g.Altitude = -g.Altitude
+		}
+	}
+	g.Time = gpsTime(entries[TagGPSDateStamp], entries[TagGPSTimeStamp])
+	if !g.HasPosition && g.Time.IsZero() {
+		return nil
+	}
+	return g
+}
+
+/*
+The GPS time as a FileDateTime in the UTC offset of exif, the EXIF date of the same image, so it matches
+the wall clock time where the image was taken. Nil if there is no GPS time.
+
+	If exif is nil or its offset is not known the local time of this machine is used. That can be a
+	different hour, or day, to the EXIF dates of images taken in another time zone.
+*/
+func (g *Gps) DateTime(exif *FileDateTime) *FileDateTime {
+	if g.Time.IsZero() {
+		return nil
+	}
+	t := g.Time.Local()
+	if exif != nil && exif.hasOffset {
+		t = g.Time.In(time.FixedZone("", exif.offset))
+	}
+	dt := NewFileDateTimeFromTime(t)
+	dt.src = SrcGps
+	return dt
+}
+
+/*
+Set the UTC offset of a wall clock time from utc, the GPS time of the same moment. The difference is rounded
+to 15 minutes so small camera clock errors do not matter. Nothing is set if it is more than 14 hours.
+*/
+func (dt *FileDateTime) setOffsetFromUTC(utc time.Time) {
+	wall := time.Date(dt.y, time.Month(dt.m), dt.d, dt.hh, dt.mm, dt.ss, 0, time.UTC)
+	diff := wall.Sub(utc).Round(15 * time.Minute)
+	if diff > 14*time.Hour || diff < -14*time.Hour {
+		return
+	}
+	dt.offset, dt.hasOffset = int(diff/time.Second), true
+}
+
+func (g *Gps) String() string {
+	var line strings.Builder
+	if g.HasPosition {
+		line.WriteString(fmt.Sprintf("Lat:%.6f Lon:%.6f", g.Latitude, g.Longitude))
+	} else {
+		line.WriteString("Lat:none Lon:none")
+	}
+	if g.HasAltitude {
+		line.WriteString(fmt.Sprintf(" Alt:%.1f", g.Altitude))
+	}
+	if !g.Time.IsZero() {
+		line.WriteString(" Time:" + g.Time.Format(time.RFC3339Nano))
+	}
+	return line.String()
+}
+
+/*
+Degrees, minutes and seconds to decimal degrees. Writers can leave out the seconds or the minutes.
+*/
+func gpsDegrees(i *IFDEntry) (float64, bool) {
+	if i == nil {
+		return 0, false
+	}
+	dms := i.Rationals()
+	if len(dms) == 0 || len(dms) > 3 {
+		return 0, false
+	}
+	deg := 0.0
+	for n, r := range dms {
+		if r.Den == 0 {
+			return 0, false
+		}
+		deg = deg + r.Float()/float64([]int{1, 60, 3600}[n])
+	}
+	return deg, true
+}
+
+func gpsRef(i *IFDEntry) string {
+	if i == nil {
+		return ""
+	}
+	return strings.ToUpper(strings.TrimSpace(i.String()))
+}
+
+/*
+GPSDateStamp (YYYY:MM:DD) and GPSTimeStamp (hour, minute and second rationals) as UTC.
+Zero if either is missing or can not be read.
+*/
+func gpsTime(date *IFDEntry, tod *IFDEntry) time.Time {
+	if date == nil || tod == nil {
+		return time.Time{}
+	}
+	// Some writers use '-'
+	d, err := time.Parse("2006:01:02", strings.ReplaceAll(strings.TrimSpace(date.String()), "-", ":"))
+	if err != nil {
+		return time.Time{}
+	}
+	hms := tod.Rationals()
+	if len(hms) != 3 {
+		return time.Time{}
+	}
+	seconds := 0.0
+	for n, r := range hms {
+		if r.Den == 0 {
+			return time.Time{}
+		}
+		seconds = seconds + r.Float()*float64([]int{3600, 60, 1}[n])
+	}
+	if seconds < 0 || seconds >= 24*3600 {
+		return time.Time{}
+	}
+	return d.Add(time.Duration(seconds * float64(time.Second))).Round(time.Millisecond)
+}
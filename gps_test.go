@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testRationals(values ...uint32) []byte {
+	b := []byte{}
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+/*
+Big endian TIFF data with a DateTime and ifd0 entries in IFD0 and a GPS IFD holding entries.
+*/
+func buildTestGpsTiff(entries []testTiffEntry, ifd0 ...testTiffEntry) []byte {
+	dateTime := []byte("2020:01:02 03:04:05\x00")
+	return buildTestTiff(false,
+		append([]testTiffEntry{
+			{tag: 306, format: FormatString, count: uint32(len(dateTime)), value: dateTime},
+			{tag: uint16(TagGPSIFD), format: FormatUint32, count: 1, subIFDs: []int{1}},
+		}, ifd0...),
+		entries,
+	)
+}
+
+func TestGps(t *testing.T) {
+	date := []byte("2019:12:31\x00")
+	tiff := buildTestGpsTiff([]testTiffEntry{
+		{tag: TagGPSLatitudeRef, format: FormatString, count: 2, value: []byte("S\x00")},
+		{tag: TagGPSLatitude, format: FormatURational, count: 3, value: testRationals(51, 1, 30, 1, 1234, 100)},
+		{tag: TagGPSLongitudeRef, format: FormatString, count: 2, value: []byte("W\x00")},
+		{tag: TagGPSLongitude, format: FormatURational, count: 3, value: testRationals(0, 1, 7, 1, 3924, 100)},
+		{tag: TagGPSAltitudeRef, format: FormatUint8, count: 1, value: []byte{1}},
+		{tag: TagGPSAltitude, format: FormatURational, count: 1, value: testRationals(1234, 10)},
+		{tag: TagGPSTimeStamp, format: FormatURational, count: 3, value: testRationals(23, 1, 59, 1, 5950, 100)},
+		{tag: TagGPSDateStamp, format: FormatString, count: uint32(len(date)), value: date},
+	})
+	dir := t.TempDir()
+	createDataFile(t, wrapTestExifJpeg(tiff), filepath.Join(dir, "gps.jpg"))
+	im, err := NewImage(filepath.Join(dir, "gps.jpg"), false, nil, logTest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gps := im.GPS()
+	if gps == nil {
+		t.Fatal("GPS should be read")
+	}
+	AssertEquals(t, gps.String(), "Lat:-51.503428 Lon:-0.127567 Alt:-123.4 Time:2019-12-31T23:59:59.5Z")
+
+	utc := time.Date(2019, 12, 31, 23, 59, 59, 0, time.UTC)
+	d := &Dict{config: newTestConfig(dir)}
+	dt := d.GetFileDateTime("gps.jpg", NewGroup("", dir, ""), logTest)
+	if dt.src != SrcDateTime {
+		t.Fatalf("GPS dates are not used by default. Actual %s src %d", dt.Spec(), dt.src)
+	}
+	d.config.GpsDates = true
+	dt = d.GetFileDateTime("gps.jpg", NewGroup("", dir, ""), logTest)
+	if dt.src != SrcGps || dt.Spec() != NewFileDateTimeFromTime(utc.Local()).Spec() {
+		t.Fatalf("Date should be from GPS. Actual %s src %d", dt.Spec(), dt.src)
+	}
+	AssertEquals(t, dt.Format("%?"), "10")
+}
+
+func TestGpsPartial(t *testing.T) {
+	dir := t.TempDir()
+	// A position with degrees and decimal minutes. No time so the EXIF date is used
+	createDataFile(t, wrapTestExifJpeg(buildTestGpsTiff([]testTiffEntry{
+		{tag: TagGPSLatitudeRef, format: FormatString, count: 2, value: []byte("N\x00")},
+		{tag: TagGPSLatitude, format: FormatURational, count: 2, value: testRationals(10, 1, 1530, 100)},
+		{tag: TagGPSLongitudeRef, format: FormatString, count: 2, value: []byte("E\x00")},
+		{tag: TagGPSLongitude, format: FormatURational, count: 2, value: testRationals(20, 1, 45, 1)},
+	})), filepath.Join(dir, "position.jpg"))
+	// A time with a zero denominator
+	date := []byte("2019-12-31\x00")
+	createDataFile(t, wrapTestExifJpeg(buildTestGpsTiff([]testTiffEntry{
+		{tag: TagGPSTimeStamp, format: FormatURational, count: 3, value: testRationals(23, 1, 59, 0, 0, 1)},
+		{tag: TagGPSDateStamp, format: FormatString, count: uint32(len(date)), value: date},
+	})), filepath.Join(dir, "badtime.jpg"))
+
+	im, _ := NewImage(filepath.Join(dir, "position.jpg"), false, nil, logTest)
+	gps := im.GPS()
+	if gps == nil {
+		t.Fatal("GPS should be read")
+	}
+	AssertEquals(t, gps.String(), "Lat:10.255000 Lon:20.750000")
+	if gps.DateTime(nil) != nil {
+		t.Fatalf("There is no GPS time. Actual %v", gps.DateTime(nil))
+	}
+	im, _ = NewImage(filepath.Join(dir, "badtime.jpg"), false, nil, logTest)
+	if im.GPS() != nil {
+		t.Fatalf("GPS should not be read. Actual %v", im.GPS())
+	}
+
+	d := &Dict{config: newTestConfig(dir)}
+	d.config.GpsDates = true
+	for _, name := range []string{"position.jpg", "badtime.jpg"} {
+		dt := d.GetFileDateTime(name, NewGroup("", dir, ""), logTest)
+		if dt.src != SrcDateTime || dt.Spec() != "20200102030405" {
+			t.Fatalf("%s: Date should be from EXIF. Actual %s src %d", name, dt.Spec(), dt.src)
+		}
+	}
+}
+
+func TestExifOffsetTime(t *testing.T) {
+	dir := t.TempDir()
+	original := []byte("2020:01:02 03:04:05\x00")
+	offset := []byte("+02:00\x00")
+	subSec := []byte("25\x00")
+	createDataFile(t, buildTestExifJpeg(false, []testTiffEntry{
+		{tag: 36867, format: FormatString, count: uint32(len(original)), value: original},
+		{tag: 36880, format: FormatString, count: uint32(len(offset)), value: []byte("-07:00\x00")}, // OffsetTime is for DateTime
+		{tag: 36881, format: FormatString, count: uint32(len(offset)), value: offset},
+		{tag: 37521, format: FormatString, count: uint32(len(subSec)), value: subSec},
+	}), filepath.Join(dir, "offset.jpg"))
+	// No OffsetTime. The offset is from the GPS time
+	date := []byte("2020:01:02\x00")
+	createDataFile(t, wrapTestExifJpeg(buildTestGpsTiff([]testTiffEntry{
+		{tag: TagGPSTimeStamp, format: FormatURational, count: 3, value: testRationals(8, 1, 33, 1, 50, 1)},
+		{tag: TagGPSDateStamp, format: FormatString, count: uint32(len(date)), value: date},
+	})), filepath.Join(dir, "gpsoffset.jpg"))
+
+	d := &Dict{config: newTestConfig(dir)}
+	d.config.ThumbNailTimeStamp = "%y%m%d_%H%M%S_%f%z_"
+	AssertEquals(t, d.GetFileTimeStamp("offset.jpg", NewGroup("", dir, ""), logTest), "20200102_030405_250+0200_")
+	// The camera clock is a little slow
+	AssertEquals(t, d.GetFileTimeStamp("gpsoffset.jpg", NewGroup("", dir, ""), logTest), "20200102_030405_000-0530_")
+
+	d.config.UtcTimeStamps = true
+	AssertEquals(t, d.GetFileTimeStamp("offset.jpg", NewGroup("", dir, ""), logTest), "20200102_010405_250+0000_")
+	AssertEquals(t, d.GetFileTimeStamp("gpsoffset.jpg", NewGroup("", dir, ""), logTest), "20200102_083405_000+0000_")
+}
+
+func TestGpsDateTimeOffset(t *testing.T) {
+	dir := t.TempDir()
+	// Taken in Tokyo at 03:04:07 local time. The camera clock is 2 seconds slow
+	date := []byte("2020:01:01\x00")
+	gpsTime := []testTiffEntry{
+		{tag: TagGPSTimeStamp, format: FormatURational, count: 3, value: testRationals(18, 1, 4, 1, 7, 1)},
+		{tag: TagGPSDateStamp, format: FormatString, count: uint32(len(date)), value: date},
+	}
+	offset := []byte("+09:00\x00")
+	createDataFile(t, wrapTestExifJpeg(buildTestGpsTiff(gpsTime, testTiffEntry{tag: 36880, format: FormatString, count: uint32(len(offset)), value: offset})), filepath.Join(dir, "tokyo.jpg"))
+	// No OffsetTime. The offset is from the EXIF and GPS times
+	createDataFile(t, wrapTestExifJpeg(buildTestGpsTiff(gpsTime)), filepath.Join(dir, "derived.jpg"))
+
+	d := &Dict{config: newTestConfig(dir)}
+	d.config.GpsDates = true
+	d.config.ThumbNailTimeStamp = "%y%m%d_%H%M%S%z_%?"
+	for _, name := range []string{"tokyo.jpg", "derived.jpg"} {
+		AssertEquals(t, d.GetFileTimeStamp(name, NewGroup("", dir, ""), logTest), "20200102_030407+0900_10")
+	}
+	d.config.UtcTimeStamps = true
+	AssertEquals(t, d.GetFileTimeStamp("tokyo.jpg", NewGroup("", dir, ""), logTest), "20200101_180407+0000_10")
+}
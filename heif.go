@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// The ftyp brands of HEIF files. Only the container matters here so the image coding is not checked.
+var heifBrands = map[string]bool{
+	"heic": true,
+	"heix": true,
+	"heim": true,
+	"heis": true,
+	"hevc": true,
+	"hevx": true,
+	"hevm": true,
+	"hevs": true,
+	"mif1": true,
+	"msf1": true,
+	"avif": true,
+}
+
+// The Exif item data starts with a 4 byte offset to the TIFF header (after 'Exif\0\0')
+const OfsHeifExifTiffHeaderOffset = 4
+
+/*
+An ISO Base Media File Format (ISO-BMFF) box.
+
+	Offset is the position of the box in the file. HeaderLen is the size, type and (for 64 bit sizes) largesize bytes.
+	Size includes the header.
+*/
+type IsoBox struct {
+	Type      string
+	Offset    uint32
+	HeaderLen uint32
+	Size      uint32
+}
+
+func (b *IsoBox) DataOffset() uint32 {
+	return b.Offset + b.HeaderLen
+}
+
+func (b *IsoBox) End() uint32 {
+	return b.Offset + b.Size
+}
+
+func (b *IsoBox) String() string {
+	return fmt.Sprintf("%s@%d:%d", b.Type, b.Offset, b.Size)
+}
+
+/*
+Read the box header at pos. The box must end at or before end.
+*/
+func readIsoBox(w *Walker, pos uint32, end uint32) (*IsoBox, error) {
+	if pos+8 > end {
+		return nil, fmt.Errorf("iso box at offset %d: header runs past the end of its parent (%d)", pos, end)
+	}
+	size := w.Pos(pos).BytesToUint(w.Bytes(4))
+	boxType := string(w.Bytes(4))
+	box := &IsoBox{Type: boxType, Offset: pos, HeaderLen: 8}
+	switch size {
+	case 0:
+		// The box runs to the end of its parent
+		size = uint64(end - pos)
+	case 1:
+		size = w.BytesToUint(w.Bytes(8))
+		box.HeaderLen = 16
+	}
+	if w.Err() != nil {
+		return nil, fmt.Errorf("iso box at offset %d: %w", pos, w.Err())
+	}
+	if size < uint64(box.HeaderLen) || uint64(pos)+size > uint64(end) {
+		return nil, fmt.Errorf("iso box '%s' at offset %d: size %d is invalid. Parent ends at %d", boxType, pos, size, end)
+	}
+	box.Size = uint32(size)
+	return box, nil
+}
+
+/*
+Read all of the boxes from start to end.
+*/
+func readIsoBoxes(w *Walker, start uint32, end uint32) ([]*IsoBox, error) {
+	boxes := []*IsoBox{}
+	for pos := start; pos < end; {
+		box, err := readIsoBox(w, pos, end)
+		if err != nil {
+			return boxes, err
+		}
+		boxes = append(boxes, box)
+		pos = box.End()
+	}
+	return boxes, nil
+}
+
+func findIsoBox(boxes []*IsoBox, boxType string) *IsoBox {
+	for _, b := range boxes {
+		if b.Type == boxType {
+			return b
+		}
+	}
+	return nil
+}
+
+/*
+True if the file starts with an ftyp box with a HEIF major or compatible brand.
+*/
+func isHeifFile(walker *Walker) bool {
+	w := walker.Clone()
+	w.littleE = false
+	size := uint32(w.Pos(0).BytesToUint(w.Bytes(4)))
+	if string(w.Bytes(4)) != "ftyp" || size < 16 || w.Err() != nil {
+		return false
+	}
+	for pos := uint32(8); pos+4 <= size; pos = pos + 4 {
+		if pos == 12 {
+			continue // minor_version
+		}
+		brand := string(w.Pos(pos).Bytes(4))
+		if w.Err() != nil {
+			return false
+		}
+		if heifBrands[brand] {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Find the Exif item in a HEIF file and return the absolute offset and size of its TIFF data.
+
+	meta (a FullBox) holds iinf, which lists the items and their types, and iloc, which says where each item is.
+	The Exif item data is a 4 byte offset to the TIFF header followed by 'Exif\0\0' and the TIFF data.
+*/
+func FindHeifExif(walker *Walker) (uint32, uint32, error) {
+	w := walker.Clone()
+	w.littleE = false // ISO-BMFF is always Big Endian
+	fileEnd := w.Len()
+
+	top, err := readIsoBoxes(w, 0, fileEnd)
+	meta := findIsoBox(top, "meta")
+	if meta == nil {
+		if err != nil {
+			return 0, 0, fmt.Errorf("heif 'meta' box is missing: %w", err)
+		}
+		return 0, 0, fmt.Errorf("heif 'meta' box is missing")
+	}
+	// meta is a FullBox. Skip version and flags
+	children, err := readIsoBoxes(w, meta.DataOffset()+4, meta.End())
+	if err != nil {
+		return 0, 0, fmt.Errorf("heif 'meta' box: %w", err)
+	}
+	iinf := findIsoBox(children, "iinf")
+	iloc := findIsoBox(children, "iloc")
+	if iinf == nil || iloc == nil {
+		return 0, 0, fmt.Errorf("heif 'iinf' or 'iloc' box is missing. Boxes%s", isoBoxesString(children))
+	}
+	exifId, err := heifExifItemId(w, iinf)
+	if err != nil {
+		return 0, 0, err
+	}
+	offset, length, err := heifItemLocation(w, iloc, exifId)
+	if err != nil {
+		return 0, 0, err
+	}
+	if length < OfsHeifExifTiffHeaderOffset {
+		return 0, 0, fmt.Errorf("heif Exif item is too small. Length %d", length)
+	}
+	tiffHeaderOffset := uint32(w.Pos(offset).BytesToUint(w.Bytes(4)))
+	if w.Err() != nil {
+		return 0, 0, fmt.Errorf("heif Exif item: %w", w.Err())
+	}
+	if tiffHeaderOffset > length-OfsHeifExifTiffHeaderOffset {
+		return 0, 0, fmt.Errorf("heif Exif item TIFF header offset %d is past the end of the item. Length %d", tiffHeaderOffset, length)
+	}
+	return offset + OfsHeifExifTiffHeaderOffset + tiffHeaderOffset, length - OfsHeifExifTiffHeaderOffset - tiffHeaderOffset, nil
+}
+
+/*
+Find the item_ID of the item with type 'Exif' in the iinf box.
+*/
+func heifExifItemId(w *Walker, iinf *IsoBox) (uint32, error) {
+	// FullBox. The version byte followed by 3 bytes of flags
+	version := w.Pos(iinf.DataOffset()).Advance(4)
+	pos := iinf.DataOffset() + 4
+	var entryCount uint64
+	if version == 0 {
+		entryCount = w.BytesToUint(w.Bytes(2))
+		pos = pos + 2
+	} else {
+		entryCount = w.BytesToUint(w.Bytes(4))
+		pos = pos + 4
+	}
+	if w.Err() != nil {
+		return 0, fmt.Errorf("heif 'iinf' box: %w", w.Err())
+	}
+	for i := uint64(0); i < entryCount && pos < iinf.End(); i++ {
+		infe, err := readIsoBox(w, pos, iinf.End())
+		if err != nil {
+			return 0, fmt.Errorf("heif 'iinf' box: %w", err)
+		}
+		pos = infe.End()
+		if infe.Type != "infe" {
+			continue
+		}
+		// Only version 2 and 3 have an item_type
+		infeVersion := w.Pos(infe.DataOffset()).Advance(4)
+		var itemId uint64
+		switch infeVersion {
+		case 2:
+			itemId = w.BytesToUint(w.Bytes(2))
+		case 3:
+			itemId = w.BytesToUint(w.Bytes(4))
+		default:
+			continue
+		}
+		w.Bytes(2) // item_protection_index
+		itemType := string(w.Bytes(4))
+		if w.Err() != nil {
+			return 0, fmt.Errorf("heif 'infe' box at offset %d: %w", infe.Offset, w.Err())
+		}
+		if itemType == "Exif" {
+			return uint32(itemId), nil
+		}
+	}
+	return 0, fmt.Errorf("heif Exif item is missing")
+}
+
+/*
+Find the file offset and length of an item in the iloc box.
+
+	Only items stored in the file (construction_method 0) are supported. Extents must be contiguous.
+*/
+func heifItemLocation(w *Walker, iloc *IsoBox, itemId uint32) (uint32, uint32, error) {
+	// FullBox. The version byte followed by 3 bytes of flags
+	version := w.Pos(iloc.DataOffset()).Advance(4)
+	sizes := w.Advance(1)
+	offsetSize := sizes >> 4
+	lengthSize := sizes & 0x0F
+	sizes = w.Advance(1)
+	baseOffsetSize := sizes >> 4
+	indexSize := uint32(0)
+	if version == 1 || version == 2 {
+		indexSize = sizes & 0x0F
+	}
+	idSize := uint32(2)
+	if version >= 2 {
+		idSize = 4
+	}
+	// The bytes before the extents of each item and the bytes in each extent
+	itemSize := uint64(idSize + 2 + baseOffsetSize + 2)
+	if version == 1 || version == 2 {
+		itemSize = itemSize + 2
+	}
+	extentSize := uint64(indexSize + offsetSize + lengthSize)
+	itemCount := w.BytesToUint(w.Bytes(idSize))
+	pos := uint64(iloc.DataOffset()) + 6 + uint64(idSize)
+	end := uint64(iloc.End())
+	if pos > end {
+		return 0, 0, fmt.Errorf("heif 'iloc' box at offset %d: header runs past the end of the box (%d)", iloc.Offset, end)
+	}
+	for i := uint64(0); i < itemCount && w.Err() == nil; i++ {
+		if pos+itemSize > end {
+			return 0, 0, fmt.Errorf("heif 'iloc' box at offset %d: item count %d runs past the end of the box (%d)", iloc.Offset, itemCount, end)
+		}
+		id := w.BytesToUint(w.Bytes(idSize))
+		constructionMethod := uint64(0)
+		if version == 1 || version == 2 {
+			constructionMethod = w.BytesToUint(w.Bytes(2)) & 0x0F
+		}
+		w.Bytes(2) // data_reference_index
+		baseOffset := w.BytesToUint(w.Bytes(baseOffsetSize))
+		extentCount := w.BytesToUint(w.Bytes(2))
+		pos = pos + itemSize
+		if pos+extentCount*extentSize > end {
+			return 0, 0, fmt.Errorf("heif 'iloc' box at offset %d: item %d extent count %d runs past the end of the box (%d)", iloc.Offset, id, extentCount, end)
+		}
+		pos = pos + extentCount*extentSize
+		var start, length uint64
+		contiguous := true
+		for e := uint64(0); e < extentCount; e++ {
+			w.Bytes(indexSize)
+			extentOffset := w.BytesToUint(w.Bytes(offsetSize))
+			extentLength := w.BytesToUint(w.Bytes(lengthSize))
+			if e == 0 {
+				start = baseOffset + extentOffset
+			} else if baseOffset+extentOffset != start+length {
+				contiguous = false
+			}
+			length = length + extentLength
+		}
+		if w.Err() != nil || uint32(id) != itemId {
+			continue
+		}
+		if !contiguous {
+			return 0, 0, fmt.Errorf("heif item %d has extents that are not contiguous", itemId)
+		}
+		if constructionMethod != 0 {
+			return 0, 0, fmt.Errorf("heif item %d construction method %d is not supported", itemId, constructionMethod)
+		}
+		if start+length > uint64(w.Len()) || length == 0 {
+			return 0, 0, fmt.Errorf("heif item %d at offset %d length %d is outside the file", itemId, start, length)
+		}
+		return uint32(start), uint32(length), nil
+	}
+	if w.Err() != nil {
+		return 0, 0, fmt.Errorf("heif 'iloc' box: %w", w.Err())
+	}
+	return 0, 0, fmt.Errorf("heif item %d is not in the 'iloc' box", itemId)
+}
+
+func isoBoxesString(boxes []*IsoBox) string {
+	var line bytes.Buffer
+	line.WriteRune('[')
+	for i, b := range boxes {
+		line.WriteString(b.String())
+		if i < (len(boxes) - 1) {
+			line.WriteRune(',')
+		}
+	}
+	line.WriteRune(']')
+	return line.String()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testIsoBox(boxType string, data ...[]byte) []byte {
+	size := 8
+	for _, d := range data {
+		size = size + len(d)
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(size))
+	b = append(b, []byte(boxType)...)
+	for _, d := range data {
+		b = append(b, d...)
+	}
+	return b
+}
+
+func testFullBox(boxType string, version byte, data ...[]byte) []byte {
+	return testIsoBox(boxType, append([][]byte{{version, 0, 0, 0}}, data...)...)
+}
+
+func testInfe(itemId uint16, itemType string) []byte {
+	b := binary.BigEndian.AppendUint16(nil, itemId)
+	b = append(b, 0, 0) // item_protection_index
+	b = append(b, []byte(itemType)...)
+	return testFullBox("infe", 2, b, []byte("\x00"))
+}
+
+/*
+Build a HEIC file with an hvc1 item and an Exif item holding tiff.
+
+	The iloc items have a base offset (the start of the mdat data) and an extent offset so both are used.
+*/
+func buildTestHeic(tiff []byte, ilocVersion byte) []byte {
+	be := binary.BigEndian
+	ftyp := testIsoBox("ftyp", []byte("mif1"), []byte{0, 0, 0, 0}, []byte("mif1heic"))
+	exif := append(be.AppendUint32(nil, 6), []byte("Exif\x00\x00")...)
+	exif = append(exif, tiff...)
+	hvc1 := []byte("not really an image")
+
+	iinf := testFullBox("iinf", 0, be.AppendUint16(nil, 2), testInfe(1, "hvc1"), testInfe(2, "Exif"))
+	iloc := func(mdatData uint32) []byte {
+		b := []byte{0x44, 0x40} // offset_size 4, length_size 4, base_offset_size 4
+		b = be.AppendUint16(b, 2)
+		for _, item := range []struct {
+			id     uint16
+			offset uint32
+			length int
+		}{{1, 0, len(hvc1)}, {2, uint32(len(hvc1)), len(exif)}} {
+			b = be.AppendUint16(b, item.id)
+			if ilocVersion == 1 {
+				b = be.AppendUint16(b, 0) // construction_method 0
+			}
+			b = be.AppendUint16(b, 0) // data_reference_index
+			b = be.AppendUint32(b, mdatData)
+			b = be.AppendUint16(b, 1)
+			b = be.AppendUint32(b, item.offset)
+			b = be.AppendUint32(b, uint32(item.length))
+		}
+		return testFullBox("iloc", ilocVersion, b)
+	}
+	hdlr := testFullBox("hdlr", 0, []byte{0, 0, 0, 0}, []byte("pict"), make([]byte, 13))
+	// The size of meta does not depend on the offsets so build it once to find where mdat starts
+	meta := testFullBox("meta", 0, hdlr, iinf, iloc(0))
+	mdatData := uint32(len(ftyp) + len(meta) + 8)
+	meta = testFullBox("meta", 0, hdlr, iinf, iloc(mdatData))
+	heic := append(ftyp, meta...)
+	return append(heic, testIsoBox("mdat", hvc1, exif)...)
+}
+
+func TestHeifExif(t *testing.T) {
+	dateTime := []byte("2021:12:25 08:09:10\x00")
+	for _, version := range []byte{0, 1} {
+		tiff := buildTestTiff(false, []testTiffEntry{
+			{tag: 274, format: FormatUint16, count: 1, value: []byte{0, 6}},
+			{tag: 306, format: FormatString, count: uint32(len(dateTime)), value: dateTime},
+		})
+		dir := t.TempDir()
+		createDataFile(t, buildTestHeic(tiff, version), filepath.Join(dir, "IMG_0001.HEIC"))
+
+		im, err := NewImage(filepath.Join(dir, "IMG_0001.HEIC"), false, nil, logTest)
+		if err != nil {
+			t.Fatalf("iloc version %d: %s", version, err)
+		}
+		AssertEquals(t, im.FileType(), ImageTypeHeif)
+		AssertEquals(t, im.Output(), "DateTime=2021:12:25 08:09:10\nOrientation=6\n")
+		if ImageOrientation(filepath.Join(dir, "IMG_0001.HEIC"), logTest) != 6 {
+			t.Fatalf("iloc version %d: Orientation should be 6", version)
+		}
+
+		d := &Dict{config: newTestConfig(dir)}
+		AssertEquals(t, d.GetFileTimeStamp("IMG_0001.HEIC", NewGroup("", dir, ""), logTest), "2021_12_25_08_09_10_")
+	}
+}
+
+func TestHeifNoExif(t *testing.T) {
+	heic := buildTestHeic(buildTestTiff(false, []testTiffEntry{{tag: 274, format: FormatUint16, count: 1, value: []byte{0, 6}}}), 0)
+	// Change the type of the Exif item. The infe boxes are before the item data
+	copy(heic[strings.Index(string(heic), "Exif"):], "mime")
+	fil := filepath.Join(t.TempDir(), "noexif.heic")
+	createDataFile(t, heic, fil)
+	_, err := NewImage(fil, false, nil, logTest)
+	if err == nil || !strings.HasPrefix(err.Error(), "heif Exif item is missing. Path:") {
+		t.Fatalf("Should fail with the Exif item missing. Actual %v", err)
+	}
+
+	// Truncated in the meta box
+	createDataFile(t, heic[:60], fil)
+	_, err = NewImage(fil, false, nil, logTest)
+	if err == nil || !strings.Contains(err.Error(), "heif 'meta' box is missing") {
+		t.Fatalf("Should fail with meta missing. Actual %v", err)
+	}
+	if errors.Is(err, ErrShortRead) {
+		t.Fatalf("The box size check should stop the read. Actual %v", err)
+	}
+}
+
+func TestHeifIlocCounts(t *testing.T) {
+	be := binary.BigEndian
+	fil := filepath.Join(t.TempDir(), "iloc.heic")
+	for _, tc := range []struct {
+		name   string
+		change func(heic []byte, iloc int)
+		err    string
+	}{
+		{"item count", func(heic []byte, iloc int) {
+			be.PutUint16(heic[iloc+10:], 0xFFFF) // item_count
+			be.PutUint16(heic[iloc+30:], 3)      // item_ID of the Exif item so it is not found
+		}, "item count 65535 runs past the end of the box"},
+		{"extent count", func(heic []byte, iloc int) {
+			be.PutUint16(heic[iloc+20:], 0xFFFF) // extent_count of the first item
+		}, "item 1 extent count 65535 runs past the end of the box"},
+	} {
+		heic := buildTestHeic(buildTestTiff(false, []testTiffEntry{{tag: 274, format: FormatUint16, count: 1, value: []byte{0, 6}}}), 0)
+		tc.change(heic, strings.Index(string(heic), "iloc"))
+		createDataFile(t, heic, fil)
+		_, err := NewImage(fil, false, nil, logTest)
+		if err == nil || !strings.Contains(err.Error(), "heif 'iloc' box at offset") || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("%s: Should fail with '%s'. Actual %v", tc.name, tc.err, err)
+		}
+	}
+}
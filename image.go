@@ -1,34 +1,99 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"time"
 )
 
 const OfsSOI = 0
-const OfsAPP1Marker = 2
-const OfsAPP1Size = 4
-const OfsExifHeader = 6
-const OfsTiffHeader = 12
-const OfsMainImageOffset = 16
+
+// Offsets relative to the start of the APP1 'Exif' segment data
+const OfsExifTiffHeader = 6
+
+// Offsets relative to the TIFF header
+const OfsTiffMainImageOffset = 4
 
 const TiffRecordSize = 12
 
+// Bytes read at a time from an image file
+const ImageWindowSize = 4096
+
+// Largest number of entries we accept in a single IFD
+const TiffMaxIFDEntries = 200
+
+// Deepest sub IFD we follow. Main IFD is 0. Exif and GPS are 1. Interoperability is 2.
+const TiffMaxIFDDepth = 8
+
+var ErrBadIFDCount = errors.New("bad IFD entry count")
+var ErrIFDLoop = errors.New("IFD already read (loop)")
+var ErrIFDTooDeep = errors.New("IFD nested too deep")
+var ErrOffsetOutsideExif = errors.New("offset outside the exif data")
+
+/*
+An error found while reading the TIFF data of an image.
+
+	IFDPath is the directory path. For example 'Main IFD/ExifOffset'.
+	Offset is the absolute file offset of the directory or entry being read.
+	Tag is the name of the tag being read. Empty if the error is in the directory itself.
+	Err is the cause. Use errors.Is with ErrShortRead, ErrOffsetOutOfRange, ErrBadIFDCount,
+	ErrIFDLoop, ErrIFDTooDeep or ErrOffsetOutsideExif.
+*/
+type ExifError struct {
+	IFDPath string
+	Offset  uint32
+	Tag     string
+	Err     error
+}
+
+func (e *ExifError) Error() string {
+	if e.Tag != "" {
+		return fmt.Sprintf("exif %s tag %s at offset %d: %s", e.IFDPath, e.Tag, e.Offset, e.Err.Error())
+	}
+	return fmt.Sprintf("exif %s at offset %d: %s", e.IFDPath, e.Offset, e.Err.Error())
+}
+
+func (e *ExifError) Unwrap() error {
+	return e.Err
+}
+
 type IFDEntry struct {
 	IFDAddress   uint32
+	IFDPath      string // The directory the entry is in. For example 'Main IFD' or 'Dir1 IFD'
 	TagData      *Tag
 	TagFormat    *TagFormat
 	ByteCount    uint32
-	Value        string
+	Value        string // Formatted value. For logging and diagnostics. Use the typed accessors to read values.
 	itemCount    uint32
 	dataOrOffset []byte
+	raw          []byte // The value bytes. Either dataOrOffset or the bytes it points to
+	littleE      bool
 }
 
-func newIFDEntry(walker *Walker) *IFDEntry {
+type Rational struct {
+	Num int64
+	Den int64
+}
+
+func (r Rational) Float() float64 {
+	if r.Den == 0 {
+		return 0
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+func (r Rational) String() string {
+	return fmt.Sprintf("%d/%d", r.Num, r.Den)
+}
+
+func newIFDEntry(walker *Walker) (*IFDEntry, error) {
 	// Do these in the right order!
 	address := walker.posit
 	tagNumber := uint32(walker.BytesToUint(walker.Bytes(2)))
@@ -37,6 +102,9 @@ func newIFDEntry(walker *Walker) *IFDEntry {
 	itemCount := uint32(walker.BytesToUint(walker.Bytes(4)))
 
 	dataOrOffset := walker.Bytes(4) // Datavalue or Offset to data value
+	if walker.Err() != nil {
+		return nil, walker.Err()
+	}
 
 	// Get the tag data from the tagNumber
 	tagData := LookUpTagData(tagNumber, walker.tagPath)
@@ -56,7 +124,7 @@ func newIFDEntry(walker *Walker) *IFDEntry {
 		Value:        "",
 		itemCount:    itemCount,
 		dataOrOffset: dataOrOffset,
-	}
+	}, nil
 
 }
 
@@ -79,29 +147,261 @@ func (p *IFDEntry) isSubDir() bool {
 	return p.TagData.IsDir
 }
 
-type image struct {
-	name       string
-	walker     *Walker
-	soi        string
-	exif       bool
-	app1Marker string
-	app1Size   uint32 // APP1 data size
-	IFDdata    []*IFDEntry
-	debug      bool
-	selectCB   func(*IFDEntry, *Walker) bool
-	logOutput  func(string, string)
-}
-
-func NewImage(imagePath string, debug bool, selectCallBack func(*IFDEntry, *Walker) bool, logOutFunc func(string, string)) (img *image, err error) {
-
-	defer func() {
-		// Main
-		if r := recover(); r != nil {
-			img = nil
-			err = fmt.Errorf("PANIC:IMG:%s", r)
+func (p *IFDEntry) byteOrder() binary.ByteOrder {
+	if p.littleE {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+/*
+The bytes for each item. Items that are not fully present in raw are dropped.
+*/
+func (p *IFDEntry) items() [][]byte {
+	n := int(p.TagFormat.byteLen)
+	count := int(p.itemCount)
+	if n == 0 || len(p.raw) < count*n {
+		count = len(p.raw) / max(n, 1)
+	}
+	items := make([][]byte, count)
+	for i := 0; i < count; i++ {
+		items[i] = p.raw[i*n : (i+1)*n]
+	}
+	return items
+}
+
+func (p *IFDEntry) isInteger() bool {
+	switch p.TagFormat.tiffFormat {
+	case FormatUint8, FormatInt8, FormatUint16, FormatInt16, FormatUint32, FormatInt32:
+		return true
+	}
+	return false
+}
+
+func (p *IFDEntry) isRational() bool {
+	return p.TagFormat.tiffFormat == FormatURational || p.TagFormat.tiffFormat == FormatRational
+}
+
+func (p *IFDEntry) isFloat() bool {
+	return p.TagFormat.tiffFormat == FormatFloat32 || p.TagFormat.tiffFormat == FormatFloat64
+}
+
+/*
+The raw value bytes as stored in the file (in the file byte order).
+*/
+func (p *IFDEntry) Bytes() []byte {
+	return p.raw
+}
+
+/*
+All items for integer formats. Signed formats are sign extended. Empty for other formats.
+*/
+func (p *IFDEntry) Ints() []int64 {
+	if !p.isInteger() {
+		return []int64{}
+	}
+	order := p.byteOrder()
+	items := p.items()
+	ints := make([]int64, len(items))
+	for i, b := range items {
+		switch p.TagFormat.tiffFormat {
+		case FormatUint8:
+			ints[i] = int64(b[0])
+		case FormatInt8:
+			ints[i] = int64(int8(b[0]))
+		case FormatUint16:
+			ints[i] = int64(order.Uint16(b))
+		case FormatInt16:
+			ints[i] = int64(int16(order.Uint16(b)))
+		case FormatUint32:
+			ints[i] = int64(order.Uint32(b))
+		case FormatInt32:
+			ints[i] = int64(int32(order.Uint32(b)))
+		}
+	}
+	return ints
+}
+
+/*
+The first item for integer formats as an unsigned value. 0 if there is none or the format is not an integer.
+*/
+func (p *IFDEntry) Uint() uint64 {
+	ints := p.Ints()
+	if len(ints) == 0 || ints[0] < 0 {
+		return 0
+	}
+	return uint64(ints[0])
+}
+
+/*
+All items for URational and Rational formats. Empty for other formats.
+*/
+func (p *IFDEntry) Rationals() []Rational {
+	if !p.isRational() {
+		return []Rational{}
+	}
+	order := p.byteOrder()
+	items := p.items()
+	rats := make([]Rational, len(items))
+	for i, b := range items {
+		if p.TagFormat.tiffFormat == FormatRational {
+			rats[i] = Rational{Num: int64(int32(order.Uint32(b[0:4]))), Den: int64(int32(order.Uint32(b[4:8])))}
+		} else {
+			rats[i] = Rational{Num: int64(order.Uint32(b[0:4])), Den: int64(order.Uint32(b[4:8]))}
+		}
+	}
+	return rats
+}
+
+/*
+All items as float64 for numeric formats. Empty for other formats.
+
+	Float and Double items are IEEE-754 values in the file byte order.
+*/
+func (p *IFDEntry) Floats() []float64 {
+	if p.isFloat() {
+		order := p.byteOrder()
+		items := p.items()
+		floats := make([]float64, len(items))
+		for i, b := range items {
+			if p.TagFormat.tiffFormat == FormatFloat32 {
+				floats[i] = float64(math.Float32frombits(order.Uint32(b)))
+			} else {
+				floats[i] = math.Float64frombits(order.Uint64(b))
+			}
+		}
+		return floats
+	}
+	if p.isRational() {
+		rats := p.Rationals()
+		floats := make([]float64, len(rats))
+		for i, r := range rats {
+			floats[i] = r.Float()
+		}
+		return floats
+	}
+	ints := p.Ints()
+	floats := make([]float64, len(ints))
+	for i, v := range ints {
+		floats[i] = float64(v)
+	}
+	return floats
+}
+
+/*
+The first item as a float64. 0 if there is none or the format is not numeric.
+*/
+func (p *IFDEntry) Float() float64 {
+	floats := p.Floats()
+	if len(floats) == 0 {
+		return 0
+	}
+	return floats[0]
+}
+
+/*
+ASCII values up to the first zero. Other formats return the formatted value.
+*/
+func (p *IFDEntry) String() string {
+	if p.TagFormat.tiffFormat == FormatString {
+		return bytesToZString(p.raw)
+	}
+	return p.formatValue()
+}
+
+/*
+Format the value for logging. Items are separated by ','. Unknown formats are shown as hex.
+*/
+func (p *IFDEntry) formatValue() string {
+	if p.TagFormat.tiffFormat == FormatString {
+		return bytesToZString(p.raw)
+	}
+	var line bytes.Buffer
+	switch {
+	case p.isInteger():
+		for i, v := range p.Ints() {
+			if i > 0 {
+				line.WriteRune(',')
+			}
+			line.WriteString(strconv.FormatInt(v, 10))
+		}
+	case p.isRational():
+		for i, v := range p.Rationals() {
+			if i > 0 {
+				line.WriteRune(',')
+			}
+			line.WriteString(v.String())
+		}
+	case p.isFloat():
+		bitSize := 64
+		if p.TagFormat.tiffFormat == FormatFloat32 {
+			bitSize = 32
 		}
-	}()
+		for i, v := range p.Floats() {
+			if i > 0 {
+				line.WriteRune(',')
+			}
+			line.WriteString(strconv.FormatFloat(v, 'g', -1, bitSize))
+		}
+	default:
+		for i, b := range p.items() {
+			if i > 0 {
+				line.WriteRune(',')
+			}
+			line.WriteString("0x")
+			line.WriteString(bytesToHex(b, 0))
+		}
+	}
+	return line.String()
+}
+
+const ImageTypeJpeg = "jpeg"
+const ImageTypeTiff = "tiff"
+const ImageTypeHeif = "heif"
+const ImageTypePng = "png"
+const ImageTypeWebp = "webp"
+const ImageTypeVideo = "video"
+
+// Returned (wrapped) by NewImage, with the image, when a PNG or WebP file has no EXIF data.
+// The image may still have other metadata. For example a PNG creation time.
+var ErrNoExif = errors.New("exif data is missing")
+
+type image struct {
+	name         string
+	walker       *Walker
+	fileType     string // ImageTypeJpeg, ImageTypeTiff, ImageTypeHeif, ImageTypePng, ImageTypeWebp or ImageTypeVideo
+	soi          string
+	exif         bool
+	app1Marker   string
+	app1Size     uint32          // APP1 data size
+	tiffBase     uint32          // Absolute offset of the TIFF header. All IFD offsets are relative to this
+	tiffSize     uint32          // Bytes of TIFF data from tiffBase. All IFD offsets must be within this
+	visited      map[uint64]bool // IFD offsets already read
+	segments     []*JpegSegment
+	texts        map[string]string // PNG text chunks and video text metadata by keyword
+	mediaCreated time.Time         // Video mvhd creation time (UTC). Zero if not known
+	xmp          map[string]string // XMP properties by 'prefix:Name'
+	iptc         *Iptc             // IPTC records from the APP13 segment. Nil if none
+	IFDdata      []*IFDEntry
+	debug        bool
+	selectCB     func(*IFDEntry, *Walker) bool
+	logOutput    func(string, string)
+}
 
+/*
+Read the EXIF data for an image.
+
+	Jpeg files are read from the APP1 'Exif' segment.
+	TIFF files and TIFF based RAW files (DNG, CR2, NEF, ARW) start with the TIFF header so the whole file is read as TIFF.
+	HEIF files (.heic) are read from the TIFF data in the 'Exif' item.
+	PNG files are read from the eXIf chunk and WebP files from the EXIF chunk.
+	If a PNG or WebP file has no EXIF data the image is returned with an error wrapping ErrNoExif.
+	If a Jpeg file has no 'Exif' segment the image is returned with the error so XMP and IPTC can still be used.
+	MP4, MOV and 3GP files have no EXIF data. The image is returned with the video metadata only.
+	Truncated or corrupt data is returned as an error. Errors in the TIFF data are an *ExifError
+	giving the IFD path and offset of the problem.
+*/
+func NewImage(imagePath string, debug bool, selectCallBack func(*IFDEntry, *Walker) bool, logOutFunc func(string, string)) (*image, error) {
 	p, err := filepath.Abs(imagePath)
 	if err != nil {
 		return nil, err
@@ -111,99 +411,260 @@ func NewImage(imagePath string, debug bool, selectCallBack func(*IFDEntry, *Walk
 		return nil, err
 	}
 	defer fil.Close()
-
-	walker, err := NewWalker(bufio.NewReader(fil), 1024)
+	stat, err := fil.Stat()
 	if err != nil {
-		panic(fmt.Sprintf("Failed to read file: %v", err))
+		return nil, err
 	}
 
+	// Only the parts of the file that are needed are read
+	walker := NewWalkerAt(fil, stat.Size(), ImageWindowSize)
+
 	walker.tagPath = MapGroupName["Idf0"]
 	image := &image{
-		debug:    debug,
-		selectCB: selectCallBack,
-		name:     imagePath,
-		walker:   walker,
-
-		soi:        walker.Pos(OfsSOI).Hex(walker.Bytes(2), ""),
-		app1Marker: walker.Pos(OfsAPP1Marker).Hex(walker.Bytes(2), ""),
-		// Always Big Endian
-		// Size includes the size bytes so sub 2
-		app1Size:  uint32(walker.Pos(OfsAPP1Size).bytesToUintBE(walker.Bytes(2)) - 2),
-		exif:      walker.Pos(OfsExifHeader).ZstringEquals("Exif"),
+		debug:     debug,
+		selectCB:  selectCallBack,
+		name:      imagePath,
+		walker:    walker,
+		soi:       walker.Pos(OfsSOI).Hex(walker.Bytes(2), ""),
 		IFDdata:   []*IFDEntry{},
+		visited:   map[uint64]bool{},
+		texts:     map[string]string{},
+		xmp:       map[string]string{},
 		logOutput: logOutFunc,
 	}
 
+	if isTiffHeader(walker.Clone().Pos(0).Bytes(4)) {
+		image.fileType = ImageTypeTiff
+		image.tiffBase = 0
+		image.tiffSize = uint32(min(stat.Size(), math.MaxUint32))
+	} else if isHeifFile(walker) {
+		image.fileType = ImageTypeHeif
+		image.tiffBase, image.tiffSize, err = FindHeifExif(walker)
+		if err != nil {
+			return nil, fmt.Errorf("%w. Path:%s", err, imagePath)
+		}
+	} else if isPngFile(walker) || isWebpFile(walker) {
+		if isPngFile(walker) {
+			image.fileType = ImageTypePng
+			err = image.findPngExif()
+		} else {
+			image.fileType = ImageTypeWebp
+			err = image.findWebpExif()
+		}
+		if err != nil {
+			// The image is returned so any other metadata can still be used
+			return image, fmt.Errorf("%w. Path:%s", err, imagePath)
+		}
+	} else if isVideoFile(walker) {
+		image.fileType = ImageTypeVideo
+		err = image.findVideoMetadata(fil, stat.Size())
+		if err != nil {
+			return nil, fmt.Errorf("%w. Path:%s", err, imagePath)
+		}
+		return image, nil
+	} else {
+		image.fileType = ImageTypeJpeg
+		err = image.findJpegExif()
+		if err != nil {
+			if len(image.segments) == 0 {
+				return nil, err
+			}
+			return image, err
+		}
+	}
+	image.exif = true
+
 	if image.debug {
-		image.logOutput(image.Diagnostics("IMG"),"")
+		image.logOutput(image.Diagnostics("IMG"), "")
 		if image.selectCB != nil {
-			if !image.selectCB(nil, walker.Clone().Pos(OfsMainImageOffset)) {
+			if !image.selectCB(nil, walker.Clone().Pos(image.tiffBase+OfsTiffMainImageOffset)) {
 				os.Exit(1)
 			}
 		}
 	}
 
 	if debug {
-		image.logOutput(walker.LinePrint(0, 12, 3),"")
+		image.logOutput(walker.LinePrint(image.tiffBase, 12, 3), "")
+	}
+
+	err = image.readTiff()
+	if err != nil {
+		return nil, fmt.Errorf("%w. Path:%s", err, imagePath)
+	}
+
+	image.sortEntries()
+
+	if image.debug {
+		for _, ifd := range image.IFDdata {
+			image.logOutput(ifd.Output(), "")
+		}
+	}
+	return image, nil
+}
+
+/*
+The TIFF base and size for an EXIF payload of size bytes at offset.
+
+	PNG and WebP payloads should start with the TIFF header but some writers add the jpeg 'Exif\0\0' identifier first.
+*/
+func exifPayload(walker *Walker, offset uint32, size uint32) (uint32, uint32) {
+	w := walker.Clone()
+	if size >= OfsExifTiffHeader && string(w.Pos(offset).Bytes(OfsExifTiffHeader)) == "Exif\x00\x00" {
+		return offset + OfsExifTiffHeader, size - OfsExifTiffHeader
 	}
+	return offset, size
+}
+
+/*
+Text metadata (PNG tEXt, zTXt and iTXt chunks or video metadata keys) by keyword.
+*/
+func (p *image) Texts() map[string]string {
+	return p.texts
+}
+
+/*
+The creation time from metadata other than EXIF. PNG text chunks or video metadata.
+
+	Nil if there is not one that can be read.
+*/
+func (p *image) CreationTime() *FileDateTime {
+	switch p.fileType {
+	case ImageTypePng:
+		return p.pngCreationTime()
+	case ImageTypeVideo:
+		return p.videoCreationTime()
+	}
+	return nil
+}
 
-	if image.soi != "FFD8" {
-		return nil, fmt.Errorf("jpeg marker 'FFD8' is missing (Offset %d) found %s. Path:%s", OfsSOI, image.soi, imagePath)
+/*
+True if b is a TIFF header. 'II' (Little Endian) or 'MM' (Big Endian) followed by 42.
+*/
+func isTiffHeader(b []byte) bool {
+	return bytes.Equal(b, []byte("II\x2a\x00")) || bytes.Equal(b, []byte("MM\x00\x2a"))
+}
+
+/*
+Find the APP1 'Exif' segment in a jpeg and set the TIFF base and size from it.
+*/
+func (p *image) findJpegExif() error {
+	walker := p.walker
+	if walker.Err() != nil {
+		return fmt.Errorf("jpeg marker 'FFD8' is missing: %w. Path:%s", walker.Err(), p.name)
 	}
-	if image.app1Marker != "FFE1" {
-		return nil, fmt.Errorf("jpeg APP1 marker 'FFE1' is missing (Offset %d) found %s. Path:%s", OfsAPP1Marker, image.app1Marker, imagePath)
+	if p.soi != "FFD8" {
+		return fmt.Errorf("jpeg marker 'FFD8' is missing (Offset %d) found %s. Path:%s", OfsSOI, p.soi, p.name)
 	}
-	if !image.exif {
-		return nil, fmt.Errorf("jpeg 'Exif' data marker is missing (Offset %d) found %s. Path:%s", OfsExifHeader, bytesToZString(walker.Pos(OfsExifHeader).Bytes(6)), imagePath)
+
+	segments, scanErr := ScanJpegSegments(walker)
+	p.segments = segments
+	p.readJpegXmp(segments)
+	p.readJpegIptc(segments)
+	if p.debug {
+		p.logOutput(fmt.Sprintf("DEBUG: Segments%s", jpegSegmentsString(segments)), "")
+		if scanErr != nil {
+			p.logOutput(fmt.Sprintf("DEBUG: %s", scanErr.Error()), "")
+		}
 	}
 
-	tiffHeader := walker.Pos(OfsTiffHeader).Zstring(2)
+	var exifSegment *JpegSegment
+	for _, s := range segments {
+		if s.IsExif() {
+			exifSegment = s
+			break
+		}
+	}
+	if exifSegment == nil {
+		if scanErr != nil {
+			return fmt.Errorf("jpeg APP1 'Exif' segment is missing. Segments%s. Error:%s. Path:%s", jpegSegmentsString(segments), scanErr.Error(), p.name)
+		}
+		return fmt.Errorf("jpeg APP1 'Exif' segment is missing. Segments%s. Path:%s", jpegSegmentsString(segments), p.name)
+	}
+
+	p.app1Marker = fmt.Sprintf("%X", exifSegment.Marker)
+	p.app1Size = exifSegment.Length
+	p.tiffBase = exifSegment.DataOffset() + OfsExifTiffHeader
+	if p.app1Size < OfsExifTiffHeader+OfsTiffMainImageOffset+4 {
+		return fmt.Errorf("%w. Path:%s", &ExifError{IFDPath: "TIFF Header", Offset: p.tiffBase, Err: fmt.Errorf("%w. APP1 size %d is too small", ErrOffsetOutsideExif, p.app1Size)}, p.name)
+	}
+	p.tiffSize = p.app1Size - OfsExifTiffHeader
+	return nil
+}
+
+/*
+Read the TIFF header at tiffBase and then the chain of IFDs (Main IFD, Dir1 IFD (thumbnail) ...).
+*/
+func (p *image) readTiff() error {
+	walker := p.walker
+	tiffHeader := walker.Pos(p.tiffBase).Zstring(2)
+	if walker.Err() != nil {
+		return &ExifError{IFDPath: "TIFF Header", Offset: p.tiffBase, Err: walker.Err()}
+	}
 	if tiffHeader == "II" {
-		image.walker.littleE = true
+		walker.littleE = true
 	} else {
 		if tiffHeader != "MM" {
-			return nil, fmt.Errorf("tiff Header 'II' or 'MM' is missing")
+			return fmt.Errorf("tiff Header 'II' or 'MM' is missing")
 		}
-		image.walker.littleE = false
+		walker.littleE = false
 	}
 	/*
 		The rest of the image data needs to know the littleE setting to work
 
 		Calc the start if the tags Using TIFF Header offset
 	*/
-	mainTiffDir := image.OffsetToAbs(walker.Pos(OfsMainImageOffset).BytesToUint(walker.Bytes(4)))
-	if debug {
-		image.logOutput(fmt.Sprintf("DEBUG: MainIFD ABS[0x%x (%d)]", mainTiffDir, mainTiffDir),"")
+	following := walker.Pos(p.tiffBase + OfsTiffMainImageOffset).BytesToUint(walker.Bytes(4))
+	if walker.Err() != nil {
+		return &ExifError{IFDPath: "TIFF Header", Offset: p.tiffBase + OfsTiffMainImageOffset, Err: walker.Err()}
+	}
+	if p.debug {
+		mainTiffDir := p.OffsetToAbs(following)
+		p.logOutput(fmt.Sprintf("DEBUG: MainIFD ABS[0x%x (%d)]", mainTiffDir, mainTiffDir), "")
 	}
 
-	var following uint64 = 1
 	count := 0
 	dirName := "Main IFD"
 
 	for following > 0 {
-		image.readDirectory(uint32(mainTiffDir), walker, dirName, 0)
-		following = image.walker.BytesToUint(image.walker.Bytes(4))
-		mainTiffDir = image.OffsetToAbs(following)
+		err := p.readDirectory(following, walker, dirName, 0)
+		if err != nil {
+			return err
+		}
+		nextPos := walker.posit
+		err = p.checkOffset(uint64(nextPos-p.tiffBase), 4)
+		if err == nil {
+			following = walker.BytesToUint(walker.Bytes(4))
+			err = walker.Err()
+		}
+		if err != nil {
+			return &ExifError{IFDPath: dirName, Offset: nextPos, Tag: "NextIFD", Err: err}
+		}
 		count++
 		dirName = fmt.Sprintf("Dir%d IFD", count)
 	}
+	return nil
+}
 
-	image.sortEntries()
-
-	if image.debug {
-		for _, ifd := range image.IFDdata {
-			image.logOutput(ifd.Output(),"")
-		}
-	}
-	return image, nil
+func (p *image) FileType() string {
+	return p.fileType
 }
 
 func (p *image) OffsetToAbs(offset uint64) uint64 {
-	return OfsTiffHeader + offset
+	return uint64(p.tiffBase) + offset
+}
+
+/*
+Check that n bytes at offset (relative to the TIFF header) are within the TIFF data.
+*/
+func (p *image) checkOffset(offset uint64, n uint64) error {
+	if offset+n > uint64(p.tiffSize) {
+		return fmt.Errorf("%w. Offset %d + %d byte(s). TIFF size %d", ErrOffsetOutsideExif, offset, n, p.tiffSize)
+	}
+	return nil
 }
 
 func (p *image) Diagnostics(m string) string {
-	return fmt.Sprintf("DEBUG:%s SOI[%s]  APP1 Mark[%s] APP1 Size[%d] FileLen[%d]Name[%s] LittleE[%t] EXIF[%t]", m, p.soi, p.app1Marker, p.app1Size, p.walker.data.length, p.name, p.walker.littleE, p.IsExif())
+	return fmt.Sprintf("DEBUG:%s SOI[%s]  APP1 Mark[%s] APP1 Size[%d] FileLen[%d]Name[%s] LittleE[%t] EXIF[%t]", m, p.soi, p.app1Marker, p.app1Size, p.walker.Len(), p.name, p.walker.littleE, p.IsExif())
 }
 
 func (p *image) Output() string {
@@ -215,12 +676,15 @@ func (p *image) Output() string {
 	return line.String()
 }
 
+/*
+Sort the entries by tag name. A tag is kept once for each IFD so the entries of every embedded image are kept.
+*/
 func (p *image) sortEntries() {
 	m := map[string]*IFDEntry{}
 	for i, x := range p.IFDdata {
 		tag, ok := MapTagsGrouped[x.TagData.TagGroup][x.TagData.TagNum]
 		if ok {
-			m[tag.Name] = x
+			m[tag.Name+"\x00"+x.IFDPath] = x
 		} else {
 			m[fmt.Sprintf("x:%4x:%d", x.TagData.TagNum, i)] = x
 		}
@@ -242,103 +706,124 @@ func (p *image) IsExif() bool {
 	return p.exif
 }
 
-func (p *image) getValueBytes(ifd *IFDEntry) []byte {
-	defer func() {
-		if r := recover(); r != nil {
-			panic(fmt.Sprintf("%s %s", r, ifd.Diagnostics("")))
-		}
-	}()
+func (p *image) Segments() []*JpegSegment {
+	return p.segments
+}
 
+func (p *image) getValueBytes(ifd *IFDEntry) ([]byte, error) {
 	byteCount := ifd.itemCount * ifd.TagFormat.byteLen
 	if (byteCount) > 4 {
 		// Location is a pointer from the IDFBase
 		// Clone the walker so we can use it to get the bytes without effecting the parser
 		w := p.walker.Clone()
-		pos := p.OffsetToAbs(w.BytesToUint(ifd.dataOrOffset))
-		return w.Pos(uint32(pos)).Bytes(byteCount)
+		offset := w.BytesToUint(ifd.dataOrOffset)
+		err := p.checkOffset(offset, uint64(byteCount))
+		if err != nil {
+			return nil, err
+		}
+		b := w.Pos(uint32(p.OffsetToAbs(offset))).Bytes(byteCount)
+		return b, w.Err()
 	} else {
 		// Location is the value
-		return ifd.dataOrOffset
+		return ifd.dataOrOffset, nil
 	}
 }
 
-func (p *image) GetIDFData(ifd *IFDEntry) string {
-	var line bytes.Buffer
-	bytes := p.getValueBytes(ifd)
-	items := int(ifd.itemCount)
-	tagFormat := ifd.TagFormat
-
-	if tagFormat.tiffFormat == FormatString {
-		return bytesToZString(bytes)
-	}
-
-	bytePos := 0
-	byteLen := int(tagFormat.byteLen)
-	for i := 0; i < items; i++ {
-		subBytes := bytes[bytePos : bytePos+byteLen]
-		switch tagFormat.tiffFormat {
-		case FormatUint8:
-			line.WriteString(fmt.Sprintf("%d", p.walker.BytesToUint(subBytes)))
-		case FormatInt8:
-			line.WriteString(fmt.Sprintf("%d", p.walker.BytesToInt(subBytes)))
-		case FormatUint16:
-			line.WriteString(fmt.Sprintf("%d", p.walker.BytesToUint(subBytes)))
-		case FormatInt16:
-			line.WriteString(fmt.Sprintf("%d", p.walker.BytesToInt(subBytes)))
-		case FormatUint32:
-			line.WriteString(fmt.Sprintf("%d", p.walker.BytesToUint(subBytes)))
-		case FormatInt32:
-			line.WriteString(fmt.Sprintf("%d", p.walker.BytesToInt(subBytes)))
-		case FormatURational:
-			n := p.walker.BytesToUint(subBytes[0:4])
-			d := p.walker.BytesToUint(subBytes[4:])
-			line.WriteString(fmt.Sprintf("%d/%d", n, d))
-		case FormatRational:
-			n := p.walker.BytesToInt(subBytes[0:4])
-			d := p.walker.BytesToInt(subBytes[4:])
-			line.WriteString(fmt.Sprintf("%d/%d", n, d))
-		default:
-			line.WriteString(p.walker.Hex(subBytes, "0x"))
-		}
-		bytePos = bytePos + byteLen
-		if i < (items - 1) {
-			line.WriteRune(',')
-		}
+/*
+Read the value bytes for the entry and return the value formatted for logging.
+*/
+func (p *image) GetIDFData(ifd *IFDEntry) (string, error) {
+	raw, err := p.getValueBytes(ifd)
+	if err != nil {
+		return "", err
 	}
-	return line.String()
+	ifd.raw = raw
+	ifd.littleE = p.walker.littleE
+	return ifd.formatValue(), nil
 }
 
-func (p *image) readDirectory(base uint32, walker *Walker, dirName string, depth int) {
-	// defer func() {
-	// 	if r := recover(); r != nil {
-	// 		panic(fmt.Sprintf("PANIC:DIR:%s", r))
-	// 	}
-	// }()
+/*
+Read the IFD at offset (relative to the TIFF header) and any sub directories it refers to.
+
+	dirPath is the path of the directory for errors and logging. For example 'Main IFD/ExifOffset'.
+	Each IFD is only read once and sub directories are only followed to TiffMaxIFDDepth, so a corrupt
+	file that points back to an IFD already read cannot loop.
+	The walker is left after the last entry, where the offset of the next IFD is.
+*/
+func (p *image) readDirectory(offset uint64, walker *Walker, dirPath string, depth int) error {
+	base := uint32(p.OffsetToAbs(offset))
+	if p.visited[offset] {
+		return &ExifError{IFDPath: dirPath, Offset: base, Err: fmt.Errorf("%w. Offset %d", ErrIFDLoop, offset)}
+	}
+	p.visited[offset] = true
+	if depth > TiffMaxIFDDepth {
+		return &ExifError{IFDPath: dirPath, Offset: base, Err: fmt.Errorf("%w. Max depth %d", ErrIFDTooDeep, TiffMaxIFDDepth)}
+	}
+	err := p.checkOffset(offset, 2)
+	if err != nil {
+		return &ExifError{IFDPath: dirPath, Offset: base, Err: err}
+	}
 	dirCount := int(walker.Pos(base).BytesToUint(walker.Bytes(2)))
-	if dirCount <= 0 || dirCount > 200 {
-		panic(fmt.Sprintf("Image TIFF data count is invalid. Expected[1..200]. Actual=[%d]", dirCount))
+	if walker.Err() != nil {
+		return &ExifError{IFDPath: dirPath, Offset: base, Err: walker.Err()}
+	}
+	if dirCount <= 0 || dirCount > TiffMaxIFDEntries {
+		return &ExifError{IFDPath: dirPath, Offset: base, Err: fmt.Errorf("%w. Expected[1..%d]. Actual=[%d]", ErrBadIFDCount, TiffMaxIFDEntries, dirCount)}
+	}
+	err = p.checkOffset(offset, uint64(2+dirCount*TiffRecordSize))
+	if err != nil {
+		return &ExifError{IFDPath: dirPath, Offset: base, Err: err}
 	}
 	for i := 0; i < dirCount; i++ {
 		current := walker.posit
-		ne := newIFDEntry(walker)
+		ne, err := newIFDEntry(walker)
+		if err != nil {
+			return &ExifError{IFDPath: dirPath, Offset: current, Err: err}
+		}
+		ne.IFDPath = dirPath
 		if ne.isSubDir() {
-			absSubDir := uint32(p.OffsetToAbs(walker.BytesToUint(ne.dataOrOffset)))
-			if p.debug {
-				wc := walker.Clone()
-				dc := wc.Pos(absSubDir).BytesToUint(wc.Bytes(2))
-				p.logOutput(fmt.Sprintf("IFD:[%s of %s :%d] %s ENTRIES[%d] DIR[%s] ABS[0x%x (%d)]", pad0(uint32(i+1), 2), pad0(uint32(dirCount), 2), depth, dirName, dc, ne.TagData.Name, absSubDir, absSubDir),"")
+			// Normally a single offset but SubIFDs in RAW files can hold several (preview, raw data ...)
+			subDirs := []uint64{walker.BytesToUint(ne.dataOrOffset)}
+			if ne.itemCount > 1 {
+				_, err = p.GetIDFData(ne)
+				if err != nil {
+					return &ExifError{IFDPath: dirPath, Offset: current, Tag: ne.TagData.Name, Err: err}
+				}
+				subDirs = subDirs[:0]
+				for _, o := range ne.Ints() {
+					subDirs = append(subDirs, uint64(o))
+				}
+			}
+			for n, subDir := range subDirs {
+				subPath := dirPath + "/" + ne.TagData.Name
+				if len(subDirs) > 1 {
+					subPath = fmt.Sprintf("%s[%d]", subPath, n)
+				}
+				absSubDir := uint32(p.OffsetToAbs(subDir))
+				if p.debug {
+					wc := walker.Clone()
+					dc := wc.Pos(absSubDir).BytesToUint(wc.Bytes(2))
+					p.logOutput(fmt.Sprintf("IFD:[%s of %s :%d] %s ENTRIES[%d] DIR[%s] ABS[0x%x (%d)]", pad0(uint32(i+1), 2), pad0(uint32(dirCount), 2), depth, dirPath, dc, ne.TagData.Name, absSubDir, absSubDir), "")
+				}
+				err = p.readDirectory(subDir, walker.CloneWithPath(ne.TagData.TagGroup), subPath, depth+1)
+				if err != nil {
+					return err
+				}
 			}
-			p.readDirectory(absSubDir, walker.CloneWithPath(ne.TagData.TagGroup), ne.TagData.Name, depth+1)
 		} else {
-			ne.Value = p.GetIDFData(ne)
+			ne.Value, err = p.GetIDFData(ne)
+			if err != nil {
+				return &ExifError{IFDPath: dirPath, Offset: current, Tag: ne.TagData.Name, Err: err}
+			}
 			if (p.selectCB != nil && p.selectCB(ne, walker.Clone().Pos(current))) || p.selectCB == nil {
 				if p.debug {
-					p.logOutput(ne.Diagnostics(fmt.Sprintf("[%s of %s :%d] %s ", pad0(uint32(i+1), 2), pad0(uint32(dirCount), 2), depth, dirName)),"")
+					p.logOutput(ne.Diagnostics(fmt.Sprintf("[%s of %s :%d] %s ", pad0(uint32(i+1), 2), pad0(uint32(dirCount), 2), depth, dirPath)), "")
 				}
 				p.IFDdata = append(p.IFDdata, ne)
 			}
 		}
 	}
+	return nil
 }
 
 func pad0(i uint32, n int) string {
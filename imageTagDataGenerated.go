@@ -152,8 +152,8 @@ var MapTiffFormats = map[TiffFormat]*TagFormat{
   FormatInt16: {tiffFormat:FormatInt16, formatName:"FormatInt16", desc:"Short int16", byteLen:2},
   FormatUndefined: {tiffFormat:FormatUndefined, formatName:"FormatUndefined", desc:"Undefined", byteLen:1},
   FormatRational: {tiffFormat:FormatRational, formatName:"FormatRational", desc:"n/d Rational", byteLen:8},
-  FormatFloat32: {tiffFormat:FormatFloat32, formatName:"FormatFloat32", desc:"Single Float32", byteLen:2},
-  FormatFloat64: {tiffFormat:FormatFloat64, formatName:"FormatFloat64", desc:"Double Float64", byteLen:4},
+  FormatFloat32: {tiffFormat:FormatFloat32, formatName:"FormatFloat32", desc:"Single Float32", byteLen:4},
+  FormatFloat64: {tiffFormat:FormatFloat64, formatName:"FormatFloat64", desc:"Double Float64", byteLen:8},
   FormatInt32: {tiffFormat:FormatInt32, formatName:"FormatInt32", desc:"Long int32", byteLen:4},// Added. It is not in data file WebScrapeEXIFData.txt: SLong
   FormatInt8: {tiffFormat:FormatInt8, formatName:"FormatInt8", desc:"Byte Signed Int8", byteLen:1},// Added. There is no spec for: SByte
 }
@@ -161,13 +161,6 @@ var MapTiffFormats = map[TiffFormat]*TagFormat{
 // Generated from input data file WebScrapeEXIFData.txt extracted from https://exiv2.org/tags.html
 // Run the code generator in codeGen.
 var MapTagsGrouped = map[string]map[uint32]*Tag{
-"Iop": { // Group!
-   1:{IsDir: false, TagNum: 1,  Name: "InteroperabilityIndex",  TagGroup: "Iop", validFormats: []TiffFormat{FormatString}, LongDesc: "Indicates the identification of the Interoperability rule. Use \"R98\" for stating ExifR98 Rules. Four bytes used including the termination code (NULL). see the separate volume of Recommended Exif Interoperability Rules (ExifR98) for other tags used for ExifR98."},
-   2:{IsDir: false, TagNum: 2,  Name: "InteroperabilityVersion",  TagGroup: "Iop", validFormats: []TiffFormat{FormatUndefined}, LongDesc: "Interoperability version"},
-   4096:{IsDir: false, TagNum: 4096,  Name: "RelatedImageFileFormat",  TagGroup: "Iop", validFormats: []TiffFormat{FormatString}, LongDesc: "File format of image file"},
-   4097:{IsDir: false, TagNum: 4097,  Name: "RelatedImageWidth",  TagGroup: "Iop", validFormats: []TiffFormat{FormatUint32}, LongDesc: "Image width"},
-   4098:{IsDir: false, TagNum: 4098,  Name: "RelatedImageLength",  TagGroup: "Iop", validFormats: []TiffFormat{FormatUint32}, LongDesc: "Image height"},
-},
 "GPSInfo": { // Group!
    0:{IsDir: false, TagNum: 0,  Name: "GPSVersionID",  TagGroup: "GPSInfo", validFormats: []TiffFormat{FormatUint8}, LongDesc: "Indicates the version of <GPSInfoIFD>. The version is given as 2.0.0.0. This tag is mandatory when <GPSInfo> tag is present. (Note: The <GPSVersionID> tag is given in bytes, unlike the <ExifVersion> tag. When the version is 2.0.0.0, the tag value is 02000000.H)."},
    1:{IsDir: false, TagNum: 1,  Name: "GPSLatitudeRef",  TagGroup: "GPSInfo", validFormats: []TiffFormat{FormatString}, LongDesc: "Indicates whether the latitude is north or south latitude. The ASCII value 'N' indicates north latitude, and 'S' is south latitude."},
@@ -568,4 +561,11 @@ var MapTagsGrouped = map[string]map[uint32]*Tag{
    45580:{IsDir: false, TagNum: 45580,  Name: "MPFPitchAngle",  TagGroup: "Idf0", validFormats: []TiffFormat{FormatUint32}, LongDesc: "MPF Pitch Angle"},
    45581:{IsDir: false, TagNum: 45581,  Name: "MPFRollAngle",  TagGroup: "Idf0", validFormats: []TiffFormat{FormatUint32}, LongDesc: "MPF Roll Angle"},
 },
+"Iop": { // Group!
+   1:{IsDir: false, TagNum: 1,  Name: "InteroperabilityIndex",  TagGroup: "Iop", validFormats: []TiffFormat{FormatString}, LongDesc: "Indicates the identification of the Interoperability rule. Use \"R98\" for stating ExifR98 Rules. Four bytes used including the termination code (NULL). see the separate volume of Recommended Exif Interoperability Rules (ExifR98) for other tags used for ExifR98."},
+   2:{IsDir: false, TagNum: 2,  Name: "InteroperabilityVersion",  TagGroup: "Iop", validFormats: []TiffFormat{FormatUndefined}, LongDesc: "Interoperability version"},
+   4096:{IsDir: false, TagNum: 4096,  Name: "RelatedImageFileFormat",  TagGroup: "Iop", validFormats: []TiffFormat{FormatString}, LongDesc: "File format of image file"},
+   4097:{IsDir: false, TagNum: 4097,  Name: "RelatedImageWidth",  TagGroup: "Iop", validFormats: []TiffFormat{FormatUint32}, LongDesc: "Image width"},
+   4098:{IsDir: false, TagNum: 4098,  Name: "RelatedImageLength",  TagGroup: "Iop", validFormats: []TiffFormat{FormatUint32}, LongDesc: "Image height"},
+},
 }
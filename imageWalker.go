@@ -4,15 +4,42 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"strings"
 )
 
+var ErrShortRead = errors.New("short read")
+var ErrOffsetOutOfRange = errors.New("offset out of range")
+
+/*
+Walk over the bytes of a file.
+
+	The bytes come from a walkerSource. NewWalker reads a stream in to a growing buffer.
+	NewWalkerAt reads windows from an io.ReaderAt so only the parts of the file that are looked at are read.
+	Reads past the end of the file do not panic. The first error is kept (see Err) and
+	after that all reads return zero values, so a parser can read a whole record and check Err once.
+	Clones start with the error of the walker they were cloned from.
+*/
 type Walker struct {
-	data    *ExtendBuffer
+	data    walkerSource
 	posit   uint32
 	littleE bool
 	tagPath string
+	err     error
+}
+
+/*
+Where a Walker gets its bytes from.
+
+	byteAt returns the byte at pos. The error says why it could not (the Walker adds the offset).
+	size is the number of bytes known to be available. For diagnostics only.
+*/
+type walkerSource interface {
+	byteAt(pos uint32) (byte, error)
+	size() uint32
 }
 
 type ExtendBuffer struct {
@@ -20,34 +47,149 @@ type ExtendBuffer struct {
 	reader   *bufio.Reader
 	buff     []uint8
 	length   uint32
+	eof      error // Set once the reader has nothing more to give
 }
 
-func NewExtendBuffer(reader *bufio.Reader, extendBy uint32) *ExtendBuffer {
+func NewExtendBuffer(reader *bufio.Reader, extendBy uint32) (*ExtendBuffer, error) {
 	eb := &ExtendBuffer{
 		reader:   reader,
 		buff:     make([]uint8, 0),
 		length:   0,
 		extendBy: extendBy,
 	}
-	eb.extend(0, 0)
-	return eb
+	_, err := eb.extend(0)
+	if err != nil {
+		return nil, err
+	}
+	return eb, nil
 }
 
-func (p *ExtendBuffer) extend(required uint32, pos uint32) {
-	buffer := make([]byte, required+p.extendBy)
-	lenRead, err := p.reader.Read(buffer)
+/*
+Read at least required more bytes (plus extendBy if available) on to the end of the buffer.
+
+	Returns the number of bytes read. Anything read is kept even if it is less than required.
+*/
+func (p *ExtendBuffer) extend(required uint32) (uint32, error) {
+	if p.eof != nil {
+		return 0, p.eof
+	}
+	// The buffer grows as bytes are read, so a large required past the end of a small file
+	// does not allocate it all. Anything read must be kept or the buffer will no longer match the file.
+	var buffer bytes.Buffer
+	n, err := io.CopyN(&buffer, p.reader, int64(required)+int64(p.extendBy))
+	lenRead := uint32(n)
+	p.buff = append(p.buff, buffer.Bytes()...)
+	p.length = p.length + lenRead
 	if err != nil {
-		panic(fmt.Sprintf("Failed to extend buffer. Required %d. Current %d, Only able to read %d. Error: %s", required, pos, lenRead, err.Error()))
+		p.eof = err
+		if lenRead < required || lenRead == 0 {
+			return lenRead, err
+		}
+	}
+	return lenRead, nil
+}
+
+func (p *ExtendBuffer) byteAt(pos uint32) (byte, error) {
+	if pos < p.length {
+		return p.buff[pos], nil
+	}
+	required := pos - p.length + 1
+	lenRead, err := p.extend(required)
+	if err == nil && pos < p.length {
+		return p.buff[pos], nil
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	return 0, fmt.Errorf("required %d more byte(s), read %d (%s)", required, lenRead, err.Error())
+}
+
+func (p *ExtendBuffer) size() uint32 {
+	return p.length
+}
+
+// The number of windows a WindowBuffer keeps. IFD entries and the values they point to are often in different windows.
+const walkerWindowCount = 4
+
+type walkerWindow struct {
+	start uint32
+	data  []byte
+}
+
+/*
+Windows on to an io.ReaderAt.
+
+	At most walkerWindowCount windows of windowSize bytes are held. The least recently used is dropped.
+	A window starts at a windowSize boundary so small steps backwards (re-reading a header) do not read again.
+*/
+type WindowBuffer struct {
+	reader     io.ReaderAt
+	fileSize   int64 // -1 if not known
+	windowSize uint32
+	windows    []*walkerWindow // Most recently used first
+}
+
+func NewWindowBuffer(reader io.ReaderAt, fileSize int64, windowSize uint32) *WindowBuffer {
+	return &WindowBuffer{
+		reader:     reader,
+		fileSize:   fileSize,
+		windowSize: max(windowSize, 16),
+		windows:    make([]*walkerWindow, 0, walkerWindowCount),
+	}
+}
+
+func (p *WindowBuffer) byteAt(pos uint32) (byte, error) {
+	for i, w := range p.windows {
+		if pos >= w.start && pos-w.start < uint32(len(w.data)) {
+			if i > 0 {
+				copy(p.windows[1:i+1], p.windows[0:i])
+				p.windows[0] = w
+			}
+			return w.data[pos-w.start], nil
+		}
+	}
+	if p.fileSize >= 0 && int64(pos) >= p.fileSize {
+		return 0, fmt.Errorf("past the end of the file. Size %d", p.fileSize)
+	}
+	start := pos - (pos % p.windowSize)
+	buffer := make([]byte, p.windowSize)
+	n, err := p.reader.ReadAt(buffer, int64(start))
+	// ReadAt returns an error if it reads less than asked for. That is expected at the end of the file.
+	if n == 0 || start+uint32(n) <= pos {
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, fmt.Errorf("past the end of the file. Read %d at %d (%s)", n, start, err.Error())
+	}
+	w := &walkerWindow{start: start, data: buffer[:n]}
+	if len(p.windows) < walkerWindowCount {
+		p.windows = append(p.windows, nil)
+	}
+	copy(p.windows[1:], p.windows[0:len(p.windows)-1])
+	p.windows[0] = w
+	return w.data[pos-w.start], nil
+}
+
+func (p *WindowBuffer) size() uint32 {
+	// Offsets are 32 bit. A larger file is reported as 4GB rather than wrapping to a smaller size
+	if p.fileSize >= 0 {
+		return uint32(min(p.fileSize, math.MaxUint32))
 	}
-	if required > uint32(lenRead) {
-		panic(fmt.Sprintf("Failed to extend buffer. Required %d. Current %d, Only able to read %d.", required, pos, lenRead))
+	var end uint32
+	for _, w := range p.windows {
+		end = max(end, w.start+uint32(len(w.data)))
 	}
-	p.buff = append(p.buff, buffer[:lenRead]...)
-	p.length = p.length + uint32(lenRead)
+	return end
 }
 
+/*
+A Walker that reads the whole stream in to memory as it is walked.
+*/
 func NewWalker(reader *bufio.Reader, extendBy uint32) (*Walker, error) {
-	buffer := NewExtendBuffer(reader, extendBy)
+	buffer, err := NewExtendBuffer(reader, extendBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
 	return &Walker{
 		data:    buffer,
 		posit:   0,
@@ -56,12 +198,35 @@ func NewWalker(reader *bufio.Reader, extendBy uint32) (*Walker, error) {
 	}, nil
 }
 
+/*
+A Walker over an io.ReaderAt (for example an *os.File or a *bytes.Reader).
+
+	At most windowSize bytes are held in memory so large files can be walked without reading all of them.
+	fileSize is used to report reads past the end. Use -1 if it is not known.
+*/
+func NewWalkerAt(reader io.ReaderAt, fileSize int64, windowSize uint32) *Walker {
+	return &Walker{
+		data:    NewWindowBuffer(reader, fileSize, windowSize),
+		posit:   0,
+		littleE: false,
+		tagPath: "*",
+	}
+}
+
+/*
+The first read error. Nil if all reads so far were OK.
+*/
+func (p *Walker) Err() error {
+	return p.err
+}
+
 func (p *Walker) Clone() *Walker {
 	return &Walker{
 		data:    p.data,
 		posit:   p.posit,
 		littleE: p.littleE,
 		tagPath: p.tagPath,
+		err:     p.err,
 	}
 }
 
@@ -71,6 +236,7 @@ func (p *Walker) CloneWithPath(path string) *Walker {
 		posit:   p.posit,
 		littleE: p.littleE,
 		tagPath: path,
+		err:     p.err,
 	}
 }
 
@@ -97,9 +263,33 @@ func (p *Walker) ZstringEquals(s string) bool {
 	return (strings.EqualFold(p.Zstring(len(s)+2), s))
 }
 
+/*
+Read n bytes. If they are not all in the file nil is returned and Err is set.
+
+	The last byte is checked before anything is allocated so a corrupt length can not ask for 4GB.
+	The error is at the first missing byte, or at the end of what has been read if the file size is not known.
+*/
 func (p *Walker) Bytes(n uint32) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+	last := uint64(p.posit) + uint64(n) - 1
+	if last > math.MaxUint32 {
+		if p.err == nil {
+			p.err = fmt.Errorf("%w at offset %d: %d bytes runs past 4GB", ErrShortRead, p.posit, n)
+		}
+		return nil
+	}
+	if p.err != nil {
+		return nil
+	}
+	if _, err := p.data.byteAt(uint32(last)); err != nil {
+		// Report the first byte that is missing, as a byte by byte read would
+		p.err = fmt.Errorf("%w at offset %d: %s", ErrShortRead, max(p.posit, p.data.size()), err.Error())
+		return nil
+	}
 	b := make([]byte, n)
-	for i := 0; uint32(i) < n; i++ {
+	for i := 0; uint32(i) < n && p.err == nil; i++ {
 		b[i] = byte(p.Advance(1))
 	}
 	return b
@@ -159,24 +349,50 @@ func (p *Walker) Char() string {
 	return string(byte(b & 0xFF))
 }
 
+/*
+Return the byte at the current position and move on n bytes.
+
+	Returns 0 (and sets Err) if the current position is past the end of the file.
+*/
 func (p *Walker) Advance(n uint32) uint32 {
-	p.ensurePos(p.posit)
-	b := p.data.buff[p.posit]
+	b, ok := p.byteAt(p.posit, ErrShortRead)
+	if !ok {
+		return 0
+	}
 	p.posit = p.posit + n
 	return uint32(b) & 0xFF
 }
 
+/*
+Move to position n. If n is past the end of the file the position is not changed and Err is set.
+*/
 func (p *Walker) Pos(n uint32) *Walker {
-	p.ensurePos(n)
-	p.posit = n
+	if _, ok := p.byteAt(n, ErrOffsetOutOfRange); ok {
+		p.posit = n
+	}
 	return p
 }
 
-func (p *Walker) ensurePos(pos uint32) {
-	if pos >= p.data.length {
-		required := pos - (p.data.length - 1)
-		p.data.extend(required, p.posit)
+/*
+The number of bytes known to be available. For diagnostics.
+*/
+func (p *Walker) Len() uint32 {
+	return p.data.size()
+}
+
+/*
+Return the byte at pos. If there is not one then record an error of kind and return false.
+*/
+func (p *Walker) byteAt(pos uint32, kind error) (byte, bool) {
+	if p.err != nil {
+		return 0, false
+	}
+	b, err := p.data.byteAt(pos)
+	if err != nil {
+		p.err = fmt.Errorf("%w at offset %d: %s", kind, pos, err.Error())
+		return 0, false
 	}
+	return b, true
 }
 
 func (q *Walker) LinePrint(start uint32, count int, lines int) string {
@@ -3,8 +3,13 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -64,15 +69,15 @@ func TestWalkerInt(t *testing.T) {
 		t.Fatal("int word-hi as hex != 4ff08ff")
 	}
 
-	defer func() {
-		if r := recover(); r != nil {
-			if r != "Failed to extend buffer. Required 1. Current 4, Only able to read 0. Error: EOF" {
-				t.Fatalf("SetPos(8) Did not panic with correct message")
-			}
-		}
-	}()
 	walker.Pos(8)
-	t.Fatal("Should not get here")
+	if !errors.Is(walker.Err(), ErrOffsetOutOfRange) {
+		t.Fatalf("SetPos(8) should be out of range. Err:%v", walker.Err())
+	}
+	AssertEquals(t, walker.Err().Error(), "offset out of range at offset 8: required 1 more byte(s), read 0 (EOF)")
+	// The position is not changed and other reads do nothing
+	if walker.posit != 4 || walker.Advance(1) != 0 || walker.posit != 4 {
+		t.Fatalf("Walker should not move after an error. Pos:%d", walker.posit)
+	}
 
 }
 
@@ -125,14 +130,13 @@ func TestWalkerHex(t *testing.T) {
 	if walker.Hex(walker.Bytes(2), "") != "C645" {
 		t.Fatal("word != c645")
 	}
-	defer func() {
-		if r := recover(); r != nil {
-			if r != "Failed to extend buffer. Required 1. Current 8, Only able to read 0. Error: EOF" {
-				t.Fatalf("Hex(2) Did not panic with correct message")
-			}
-		}
-	}()
-	walker.Hex(walker.Bytes(2), "") // Will take it past end
+	if walker.Err() != nil {
+		t.Fatalf("Unexpected error %s", walker.Err())
+	}
+	if walker.Bytes(2) != nil { // Will take it past end
+		t.Fatal("word past the end should be nil")
+	}
+	AssertEquals(t, walker.Err().Error(), "short read at offset 8: required 1 more byte(s), read 0 (EOF)")
 }
 
 // : FF D8 FF E1 8E 1F 45 78 69 66 00 00 4D 4D 00 2A 00 00 00 08 00 09 88 25 00 04 00 00 00 01 00 00 03 F9 01 10 00 02 00 00 00 08 00 00 00 7A 02 13 00 03 065496 065505 036383 017784 026982 000000 019789 000042 000000 000008 000009 034853 000004 000000 000001 000000 001017 000272 000002 000000 000008 000000 000122 000531 000003
@@ -185,13 +189,6 @@ func TestWalkerRead10(t *testing.T) {
 }
 
 func TestWalkerAdvanceOffEnd(t *testing.T) {
-	defer func() {
-		if r := recover(); r != nil {
-			if r != "Failed to extend buffer. Required 1. Current 50, Only able to read 0. Error: EOF" {
-				t.Fatalf("Advance(1) Did not panic with correct message")
-			}
-		}
-	}()
 	createDataFile(t, td1, tdFileJpeg)
 	w, err := NewWalker(createReader(t, tdFileJpeg), 10)
 	if err != nil {
@@ -204,8 +201,15 @@ func TestWalkerAdvanceOffEnd(t *testing.T) {
 			t.Fatalf("Byte should be %d actual %d", td1[i], b)
 		}
 	}
-	// Following should panic EOF
-	w.Advance(1)
+	// Following should fail EOF
+	if w.Advance(1) != 0 || !errors.Is(w.Err(), ErrShortRead) {
+		t.Fatalf("Advance(1) should be a short read. Err:%v", w.Err())
+	}
+	AssertEquals(t, w.Err().Error(), "short read at offset 50: required 1 more byte(s), read 0 (EOF)")
+	// Clones keep the error
+	if w.Clone().Pos(0).Advance(1) != 0 || w.Clone().Err() == nil {
+		t.Fatal("Clone should keep the error")
+	}
 }
 
 func TestWalkerLastByted(t *testing.T) {
@@ -232,13 +236,6 @@ func TestWalkerLastByted(t *testing.T) {
 }
 
 func TestWalkerPosPastEnd(t *testing.T) {
-	defer func() {
-		if r := recover(); r != nil {
-			if r != "Failed to extend buffer. Required 4. Current 37, Only able to read 2." {
-				t.Fatalf("w.Pos(51) Did not panic with correct message")
-			}
-		}
-	}()
 	createDataFile(t, td1, tdFileJpeg)
 	w, err := NewWalker(createReader(t, tdFileJpeg), 10)
 	if err != nil {
@@ -247,15 +244,103 @@ func TestWalkerPosPastEnd(t *testing.T) {
 	w.Pos(37)
 	// Length of buffer is not 37+10
 	//
-	// Following should panic EOF. File only has 3 to go we wanted 4
+	// Following should fail EOF. File only has 3 to go we wanted 4
 	w.Pos(51)
+	if !errors.Is(w.Err(), ErrOffsetOutOfRange) {
+		t.Fatalf("w.Pos(51) should be out of range. Err:%v", w.Err())
+	}
+	AssertEquals(t, w.Err().Error(), "offset out of range at offset 51: required 4 more byte(s), read 2 (EOF)")
+}
+
+type countingReaderAt struct {
+	r     io.ReaderAt
+	reads int
+	bytes int
+}
+
+func (c *countingReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	c.reads++
+	n, err := c.r.ReadAt(b, off)
+	c.bytes += n
+	return n, err
+}
+
+func TestWalkerAt(t *testing.T) {
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	for _, size := range []int64{int64(len(data)), -1} {
+		cr := &countingReaderAt{r: bytes.NewReader(data)}
+		w := NewWalkerAt(cr, size, 64)
+
+		// A far seek only reads the window it needs
+		w.Pos(90000)
+		if w.Advance(1) != uint32(data[90000]) || cr.reads != 1 || cr.bytes != 64 {
+			t.Fatalf("Size:%d Far seek should read one window. Reads:%d Bytes:%d", size, cr.reads, cr.bytes)
+		}
+		// Same results as the stream walker across window boundaries, in both byte orders
+		w.SetLittleE(true)
+		if w.Pos(60).BytesToUint(w.Bytes(4)) != uint64(binary.LittleEndian.Uint32(data[60:])) {
+			t.Fatalf("Size:%d LE uint32 across a window boundary is wrong", size)
+		}
+		w.SetLittleE(false)
+		if w.Pos(126).BytesToUint(w.Bytes(2)) != uint64(binary.BigEndian.Uint16(data[126:])) {
+			t.Fatalf("Size:%d BE uint16 across a window boundary is wrong", size)
+		}
+		// Going back to windows still held does not read again
+		reads := cr.reads
+		w.Pos(90001).Advance(1)
+		w.Pos(61).Advance(1)
+		if cr.reads != reads {
+			t.Fatalf("Size:%d Held windows should not be read again", size)
+		}
+		if w.Err() != nil {
+			t.Fatal(w.Err())
+		}
+		w.Pos(uint32(len(data)))
+		if !errors.Is(w.Err(), ErrOffsetOutOfRange) {
+			t.Fatalf("Size:%d Pos past the end should be out of range. Err:%v", size, w.Err())
+		}
+	}
+}
+
+func TestWalkerBytesCorruptLength(t *testing.T) {
+	createDataFile(t, td1, tdFileJpeg)
+	defer removeDataFile(tdFileJpeg)
+	stream, err := NewWalker(createReader(t, tdFileJpeg), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walkers := map[string]*Walker{
+		"stream":     stream,
+		"at":         NewWalkerAt(bytes.NewReader(td1), int64(len(td1)), 16),
+		"at no size": NewWalkerAt(bytes.NewReader(td1), -1, 16),
+	}
+	for name, w := range walkers {
+		// A length near 4GB is an error without being allocated or read
+		if w.Pos(20).Bytes(0xFFFFFF00) != nil || !errors.Is(w.Err(), ErrShortRead) {
+			t.Fatalf("%s: A length past the end should be a short read. Err:%v", name, w.Err())
+		}
+		if name != "at no size" {
+			AssertEquals(t, name+" "+w.Err().Error()[:len("short read at offset 50")], name+" short read at offset 50")
+		}
+		// After an error nothing more is read
+		if w.Pos(0).Bytes(2) != nil {
+			t.Fatalf("%s: Bytes after an error should be nil", name)
+		}
+	}
+	// A length that runs past 4GB
+	w := NewWalkerAt(bytes.NewReader(td1), int64(len(td1)), 16)
+	w.Pos(20).Bytes(math.MaxUint32)
+	AssertEquals(t, w.Err().Error(), "short read at offset 20: 4294967295 bytes runs past 4GB")
 }
 
 func TestBadExifMarker(t *testing.T) {
 	createDataFile(t, td1, tdFileJpeg)
 	defer removeDataFile(tdFileJpeg)
 	_, err := NewImage(tdFileJpeg, true, nil, logTest)
-	if err.Error() != "jpeg 'Exif' data marker is missing (Offset 6) found Fxif. Path:td1.jpg" {
+	if err.Error() != "jpeg APP1 'Exif' segment is missing. Segments[SOI@0:0,APP1(Fxif)@2:36381]. Error:jpeg segment scan stopped: offset out of range at offset 36387: past the end of the file. Size 50. Path:td1.jpg" {
 		t.Fatalf("TD1 %s", err.Error())
 	}
 }
@@ -273,7 +358,7 @@ func TestBadA001(t *testing.T) {
 	createDataFile(t, td3, tdFileJpeg)
 	defer removeDataFile(tdFileJpeg)
 	_, err := NewImage(tdFileJpeg, true, nil, logTest)
-	if err.Error() != "jpeg APP1 marker 'FFE1' is missing (Offset 2) found FFEF. Path:td1.jpg" {
+	if err.Error() != "jpeg APP1 'Exif' segment is missing. Segments[SOI@0:0,APP15(JFIF)@2:14,DQT@20:130]. Error:jpeg segment scan stopped: offset out of range at offset 154: past the end of the file. Size 50. Path:td1.jpg" {
 		t.Fatalf("BadA001 %s", err.Error())
 	}
 }
@@ -282,7 +367,7 @@ func TestBadJpg(t *testing.T) {
 	createDataFile(t, td4, tdFileJpeg)
 	defer removeDataFile(tdFileJpeg)
 	_, err := NewImage(tdFileJpeg, true, nil, logTest)
-	if err.Error() != "jpeg 'Exif' data marker is missing (Offset 6) found JFIF. Path:td1.jpg" {
+	if err.Error() != "jpeg APP1 'Exif' segment is missing. Segments[SOI@0:0,APP1(JFIF)@2:14,DQT@20:130]. Error:jpeg segment scan stopped: offset out of range at offset 154: past the end of the file. Size 50. Path:td1.jpg" {
 		t.Fatalf("%s", err.Error())
 	}
 }
@@ -326,9 +411,47 @@ func TestImage02(t *testing.T) {
 	_, err := NewImage("testdata/test_data_02.ti", false, func(ifd *IFDEntry, w *Walker) bool {
 		return strings.Contains(ifd.TagData.Name, "Date")
 	}, logTest)
-	if err.Error() != "jpeg APP1 marker 'FFE1' is missing (Offset 2) found FFE0. Path:testdata/test_data_02.ti" {
+	if err.Error() != "jpeg APP1 'Exif' segment is missing. Segments[SOI@0:0,APP0(JFIF)@2:14,DQT@20:130,DRI@154:2,SOF0@160:15,DHT@179:416,SOS@599:10]. Path:testdata/test_data_02.ti" {
+		t.Fatal(err)
+	}
+}
+
+func TestImageJfifBeforeExif(t *testing.T) {
+	data, err := os.ReadFile("testdata/test_data_01.ti")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Insert an APP0 JFIF segment after SOI so the Exif APP1 is no longer at offset 2
+	jfif := []byte{0xFF, 0xE0, 0x00, 0x10, 0x4A, 0x46, 0x49, 0x46, 0x00, 0x01, 0x01, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00}
+	jpeg := append(append(append([]byte{}, data[:2]...), jfif...), data[2:]...)
+	createDataFile(t, jpeg, tdFileJpeg)
+	defer removeDataFile(tdFileJpeg)
+
+	im, err := NewImage(tdFileJpeg, false, func(ifd *IFDEntry, w *Walker) bool {
+		return strings.Contains(ifd.TagData.Name, "Date")
+	}, logTest)
+	if err != nil {
 		t.Fatal(err)
 	}
+	if im.Output() != golden {
+		t.Fatalf("Output is not same a golden:\n'%s'\n'%s'", im.Output(), golden)
+	}
+	AssertEquals(t, jpegSegmentsString(im.Segments()[:3]), "[SOI@0:0,APP0(JFIF)@2:14,APP1(Exif)@20:36381]")
+}
+
+func TestScanJpegSegments(t *testing.T) {
+	walker, err := NewWalker(createReader(t, "testdata/test_data_02.ti"), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments, err := ScanJpegSegments(walker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, jpegSegmentsString(segments), "[SOI@0:0,APP0(JFIF)@2:14,DQT@20:130,DRI@154:2,SOF0@160:15,DHT@179:416,SOS@599:10]")
+	if segments[1].DataOffset() != 6 || !segments[1].IsApp(0) || segments[1].IsExif() {
+		t.Fatalf("APP0 segment is wrong %s", segments[1])
+	}
 }
 
 func TestImage03(t *testing.T) {
@@ -354,6 +477,336 @@ func TestImage03(t *testing.T) {
 	})
 }
 
+func TestImageTypedValues(t *testing.T) {
+	im, err := NewImage("testdata/test_data_01.ti", false, nil, logTest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]*IFDEntry{}
+	for _, e := range im.IFDdata {
+		entries[e.TagData.Name] = e
+	}
+	if entries["Orientation"].Uint() != 6 {
+		t.Fatalf("Orientation should be 6 actual %d", entries["Orientation"].Uint())
+	}
+	if entries["PixelXDimension"].Uint() != 4160 {
+		t.Fatalf("PixelXDimension should be 4160 actual %d", entries["PixelXDimension"].Uint())
+	}
+	r := entries["XResolution"].Rationals()
+	if len(r) != 1 || r[0].Num != 72 || r[0].Den != 1 {
+		t.Fatalf("XResolution should be 72/1 actual %v", r)
+	}
+	if entries["FNumber"].Float() != 2.4 {
+		t.Fatalf("FNumber should be 2.4 actual %f", entries["FNumber"].Float())
+	}
+	AssertEquals(t, entries["DateTimeOriginal"].String(), "2016:11:06 11:29:18")
+	AssertEquals(t, bytesToHex(entries["ComponentsConfiguration"].Bytes(), ','), "01,02,03,00")
+	if len(entries["ComponentsConfiguration"].Ints()) != 0 || entries["Make"].Float() != 0 {
+		t.Fatal("Non numeric values should have no numbers")
+	}
+	// The string form is kept for logging
+	AssertEquals(t, entries["XResolution"].Value, "72/1")
+	AssertEquals(t, entries["ComponentsConfiguration"].Value, "0x01,0x02,0x03,0x00")
+}
+
+type testTiffEntry struct {
+	tag     uint16
+	format  TiffFormat
+	count   uint32
+	value   []byte // In the file byte order
+	subIFDs []int  // If set the value is the offsets of these IFDs (index in to the ifds given to buildTestTiff)
+}
+
+func (e testTiffEntry) valueLen() int {
+	if len(e.subIFDs) > 0 {
+		return len(e.subIFDs) * 4
+	}
+	return len(e.value)
+}
+
+/*
+Build TIFF data holding ifds. ifds[0] is the main IFD. The others are only reachable through subIFDs.
+
+	Each IFD is followed by the values longer than 4 bytes for its entries. The entries hold their offsets.
+*/
+func buildTestTiff(littleE bool, ifds ...[]testTiffEntry) []byte {
+	var order binary.AppendByteOrder = binary.BigEndian
+	tiff := []byte("MM\x00\x2a")
+	if littleE {
+		order = binary.LittleEndian
+		tiff = []byte("II\x2a\x00")
+	}
+	offsets := make([]uint32, len(ifds))
+	next := uint32(8)
+	for i, entries := range ifds {
+		offsets[i] = next
+		next = next + uint32(2+len(entries)*TiffRecordSize+4)
+		for _, e := range entries {
+			if e.valueLen() > 4 {
+				next = next + uint32(e.valueLen())
+			}
+		}
+	}
+	tiff = order.AppendUint32(tiff, offsets[0])
+	for i, entries := range ifds {
+		dataOffset := offsets[i] + uint32(2+len(entries)*TiffRecordSize+4)
+		var data []byte
+		tiff = order.AppendUint16(tiff, uint16(len(entries)))
+		for _, e := range entries {
+			value := e.value
+			if len(e.subIFDs) > 0 {
+				value = nil
+				for _, n := range e.subIFDs {
+					value = order.AppendUint32(value, offsets[n])
+				}
+			}
+			tiff = order.AppendUint16(tiff, e.tag)
+			tiff = order.AppendUint16(tiff, uint16(e.format))
+			tiff = order.AppendUint32(tiff, e.count)
+			if len(value) > 4 {
+				tiff = order.AppendUint32(tiff, dataOffset+uint32(len(data)))
+				data = append(data, value...)
+			} else {
+				tiff = append(tiff, append(value, make([]byte, 4-len(value))...)...)
+			}
+		}
+		tiff = order.AppendUint32(tiff, 0)
+		tiff = append(tiff, data...)
+	}
+	return tiff
+}
+
+/*
+Build a minimal jpeg with an APP1 Exif segment holding a single IFD with entries.
+*/
+func buildTestExifJpeg(littleE bool, entries []testTiffEntry) []byte {
+	return wrapTestExifJpeg(buildTestTiff(littleE, entries))
+}
+
+/*
+Wrap TIFF data in a jpeg APP1 Exif segment.
+*/
+func wrapTestExifJpeg(tiff []byte) []byte {
+	jpg := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	jpg = binary.BigEndian.AppendUint16(jpg, uint16(2+6+len(tiff)))
+	jpg = append(jpg, []byte("Exif\x00\x00")...)
+	jpg = append(jpg, tiff...)
+	return append(jpg, 0xFF, 0xD9)
+}
+
+func TestImageFloatValues(t *testing.T) {
+	for _, littleE := range []bool{true, false} {
+		var order binary.AppendByteOrder = binary.BigEndian
+		if littleE {
+			order = binary.LittleEndian
+		}
+		f32 := order.AppendUint32(nil, math.Float32bits(1.5))
+		f32x2 := order.AppendUint32(order.AppendUint32(nil, math.Float32bits(-0.25)), math.Float32bits(3e10))
+		f64 := order.AppendUint64(nil, math.Float64bits(2.0/3.0))
+		fil := filepath.Join(t.TempDir(), "float.jpg")
+		createDataFile(t, buildTestExifJpeg(littleE, []testTiffEntry{
+			{tag: 282, format: FormatFloat32, count: 1, value: f32},   // XResolution. In the entry
+			{tag: 283, format: FormatFloat64, count: 1, value: f64},   // YResolution. Offset
+			{tag: 318, format: FormatFloat32, count: 2, value: f32x2}, // WhitePoint. Offset
+			{tag: 274, format: FormatUint16, count: 1, value: order.AppendUint16(nil, 6)},
+		}), fil)
+
+		im, err := NewImage(fil, false, nil, logTest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := map[string]*IFDEntry{}
+		for _, e := range im.IFDdata {
+			entries[e.TagData.Name] = e
+		}
+		if entries["XResolution"].ByteCount != 4 || entries["YResolution"].ByteCount != 8 || entries["WhitePoint"].ByteCount != 8 {
+			t.Fatalf("LittleE:%t Float byte counts are wrong", littleE)
+		}
+		if entries["XResolution"].Float() != 1.5 {
+			t.Fatalf("LittleE:%t XResolution should be 1.5 actual %f", littleE, entries["XResolution"].Float())
+		}
+		if entries["YResolution"].Float() != 2.0/3.0 {
+			t.Fatalf("LittleE:%t YResolution should be 2/3 actual %f", littleE, entries["YResolution"].Float())
+		}
+		wp := entries["WhitePoint"].Floats()
+		if len(wp) != 2 || wp[0] != -0.25 || wp[1] != float64(float32(3e10)) {
+			t.Fatalf("LittleE:%t WhitePoint should be [-0.25 3e10] actual %v", littleE, wp)
+		}
+		AssertEquals(t, entries["XResolution"].Value, "1.5")
+		AssertEquals(t, entries["YResolution"].Value, "0.6666666666666666")
+		AssertEquals(t, entries["WhitePoint"].Value, "-0.25,3e+10")
+		// The entry after the floats must still be read from the right place
+		if entries["Orientation"].Uint() != 6 {
+			t.Fatalf("LittleE:%t Orientation should be 6 actual %d", littleE, entries["Orientation"].Uint())
+		}
+	}
+}
+
+func TestImageCorruptExif(t *testing.T) {
+	be := binary.BigEndian
+	orientation := testTiffEntry{tag: 274, format: FormatUint16, count: 1, value: be.AppendUint16(nil, 6)}
+	tests := []struct {
+		name    string
+		jpg     []byte
+		is      error
+		ifdPath string
+		tag     string
+		msg     string
+	}{
+		{
+			name: "BadCount",
+			jpg: func() []byte {
+				b := buildTestExifJpeg(false, []testTiffEntry{orientation})
+				b[20], b[21] = 0, 0 // IFD entry count
+				return b
+			}(),
+			is: ErrBadIFDCount, ifdPath: "Main IFD",
+			msg: "exif Main IFD at offset 20: bad IFD entry count. Expected[1..200]. Actual=[0]. Path:",
+		},
+		{
+			name: "SubDirOutside",
+			jpg: buildTestExifJpeg(false, []testTiffEntry{
+				{tag: 34665, format: FormatUint32, count: 1, value: be.AppendUint32(nil, 26)}, // Points at the jpeg EOI marker
+			}),
+			is: ErrOffsetOutsideExif, ifdPath: "Main IFD/ExifTag",
+			msg: "exif Main IFD/ExifTag at offset 38: offset outside the exif data. Offset 26 + 2 byte(s). TIFF size 26. Path:",
+		},
+		{
+			name: "ValueOffset",
+			jpg: buildTestExifJpeg(false, []testTiffEntry{
+				orientation,
+				{tag: 282, format: FormatURational, count: 1, value: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+			}),
+			is: ErrOffsetOutsideExif, ifdPath: "Main IFD", tag: "XResolution",
+		},
+		{
+			name: "ValueShortRead",
+			jpg: buildTestExifJpeg(false, []testTiffEntry{
+				orientation,
+				{tag: 282, format: FormatURational, count: 1, value: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+			})[:54],
+			is: ErrShortRead, ifdPath: "Main IFD", tag: "XResolution",
+			msg: "exif Main IFD tag XResolution at offset 34: short read at offset 54: past the end of the file. Size 54. Path:",
+		},
+		{
+			name: "Truncated",
+			jpg:  buildTestExifJpeg(false, []testTiffEntry{orientation, orientation})[:30],
+			is:   ErrShortRead, ifdPath: "Main IFD",
+			msg: "exif Main IFD at offset 22: short read at offset 30: past the end of the file. Size 30. Path:",
+		},
+		{
+			name: "NextIFDLoop",
+			jpg: func() []byte {
+				b := buildTestExifJpeg(false, []testTiffEntry{orientation})
+				be.PutUint32(b[34:], 8) // Next IFD is the Main IFD
+				return b
+			}(),
+			is: ErrIFDLoop, ifdPath: "Dir1 IFD",
+			msg: "exif Dir1 IFD at offset 20: IFD already read (loop). Offset 8. Path:",
+		},
+		{
+			name: "SubDirLoop",
+			jpg: buildTestExifJpeg(false, []testTiffEntry{
+				{tag: 34665, format: FormatUint32, count: 1, value: be.AppendUint32(nil, 8)}, // Points at its own IFD
+			}),
+			is: ErrIFDLoop, ifdPath: "Main IFD/ExifTag",
+		},
+		{
+			name: "TooDeep",
+			jpg: func() []byte {
+				// A chain of IFDs each with an ExifTag pointing at the next
+				tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+				for i := 0; i < TiffMaxIFDDepth+2; i++ {
+					tiff = be.AppendUint16(tiff, 1)
+					tiff = be.AppendUint16(tiff, 34665)
+					tiff = be.AppendUint16(tiff, uint16(FormatUint32))
+					tiff = be.AppendUint32(tiff, 1)
+					tiff = be.AppendUint32(tiff, uint32(len(tiff)+8))
+					tiff = be.AppendUint32(tiff, 0)
+				}
+				return wrapTestExifJpeg(tiff)
+			}(),
+			is: ErrIFDTooDeep, ifdPath: "Main IFD" + strings.Repeat("/ExifTag", TiffMaxIFDDepth+1),
+		},
+	}
+	for _, tc := range tests {
+		if tc.name == "ValueOffset" {
+			// Move the rational value to the last byte of the file so reading it runs off the end
+			binary.BigEndian.PutUint32(tc.jpg[12+8+2+12+8:], uint32(len(tc.jpg)-12-1))
+		}
+		fil := filepath.Join(t.TempDir(), tc.name+".jpg")
+		createDataFile(t, tc.jpg, fil)
+		im, err := NewImage(fil, false, nil, logTest)
+		if err == nil || im != nil {
+			t.Fatalf("%s: should fail", tc.name)
+		}
+		if !errors.Is(err, tc.is) {
+			t.Fatalf("%s: error should be %s. Actual %s", tc.name, tc.is, err)
+		}
+		var exifErr *ExifError
+		if !errors.As(err, &exifErr) {
+			t.Fatalf("%s: error should be an ExifError. Actual %s", tc.name, err)
+		}
+		AssertEquals(t, exifErr.IFDPath, tc.ifdPath)
+		AssertEquals(t, exifErr.Tag, tc.tag)
+		if tc.msg != "" {
+			AssertEquals(t, err.Error(), tc.msg+fil)
+		}
+	}
+}
+
+func TestImageTiffRaw(t *testing.T) {
+	for _, littleE := range []bool{true, false} {
+		var order binary.AppendByteOrder = binary.BigEndian
+		if littleE {
+			order = binary.LittleEndian
+		}
+		dateTime := []byte("2019:05:04 10:11:12\x00")
+		// Main IFD with 2 SubIFDs (like DNG and NEF) and an Exif IFD (like CR2 and ARW)
+		tiff := buildTestTiff(littleE,
+			[]testTiffEntry{
+				{tag: 274, format: FormatUint16, count: 1, value: order.AppendUint16(nil, 8)},
+				{tag: 330, format: FormatUint32, count: 2, subIFDs: []int{1, 2}},
+				{tag: 34665, format: FormatUint32, count: 1, subIFDs: []int{3}},
+			},
+			[]testTiffEntry{{tag: 256, format: FormatUint32, count: 1, value: order.AppendUint32(nil, 6000)}},
+			[]testTiffEntry{{tag: 257, format: FormatUint32, count: 1, value: order.AppendUint32(nil, 4000)}},
+			[]testTiffEntry{{tag: 36867, format: FormatString, count: uint32(len(dateTime)), value: dateTime}},
+		)
+		dir := t.TempDir()
+		createDataFile(t, tiff, filepath.Join(dir, "raw.dng"))
+
+		paths := map[string]string{}
+		im, err := NewImage(filepath.Join(dir, "raw.dng"), false, func(ifd *IFDEntry, w *Walker) bool {
+			paths[ifd.TagData.Name] = w.tagPath
+			return true
+		}, logTest)
+		if err != nil {
+			t.Fatalf("LittleE:%t %s", littleE, err)
+		}
+		AssertEquals(t, im.FileType(), ImageTypeTiff)
+		entries := map[string]*IFDEntry{}
+		for _, e := range im.IFDdata {
+			entries[e.TagData.Name] = e
+		}
+		if len(entries) != 4 || entries["ImageWidth"].Uint() != 6000 || entries["ImageLength"].Uint() != 4000 || entries["Orientation"].Uint() != 8 {
+			t.Fatalf("LittleE:%t Entries from every IFD should be read. Actual %v", littleE, im.Output())
+		}
+		AssertEquals(t, entries["DateTimeOriginal"].String(), "2019:05:04 10:11:12")
+
+		dt := (&Dict{config: newTestConfig(dir)}).GetFileDateTime("raw.dng", NewGroup("", dir, ""), logTest)
+		if dt == nil || dt.src != 1 || dt.Spec() != "20190504101112" {
+			t.Fatalf("LittleE:%t RAW file date should be from DateTimeOriginal. Actual %v", littleE, dt)
+		}
+	}
+	// Jpeg files are still found by their SOI marker
+	im, err := NewImage("testdata/test_data_01.ti", false, nil, logTest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, im.FileType(), ImageTypeJpeg)
+}
+
 func logTest(s string, x string) {
 	fmt.Println(s)
 }
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The APPn identifier of Photoshop image resources
+const JpegPhotoshopIdent = "Photoshop 3.0"
+
+// The Photoshop image resource that holds the IPTC-IIM records
+const PhotoshopResourceIptc = 0x0404
+
+// The IPTC-IIM datasets we read. Record 2 (Application) except for the character set.
+const (
+	IptcCodedCharacterSet   = 0x015A // 1:90
+	IptcKeywords            = 0x0219 // 2:25
+	IptcDateCreated         = 0x0237 // 2:55 CCYYMMDD
+	IptcTimeCreated         = 0x023C // 2:60 HHMMSS±HHMM
+	IptcDigitalCreationDate = 0x023E // 2:62
+	IptcDigitalCreationTime = 0x023F // 2:63
+	IptcByLine              = 0x0250 // 2:80
+	IptcCaption             = 0x0278 // 2:120
+)
+
+// Each dataset starts with this tag marker
+const iptcTagMarker = 0x1C
+
+// The coded character set escape sequence for UTF-8
+const iptcUTF8 = "\x1b%G"
+
+/*
+The IPTC-IIM metadata for an image.
+
+	Keywords and ByLine can have more than one value.
+*/
+type Iptc struct {
+	DateCreated         string
+	TimeCreated         string
+	DigitalCreationDate string
+	DigitalCreationTime string
+	Caption             string
+	Keywords            []string
+	ByLine              []string
+}
+
+/*
+Read the IPTC-IIM datasets from data. Unknown datasets are skipped.
+
+	Text is UTF-8 if the coded character set says so (or it is valid UTF-8) otherwise it is read as Latin-1.
+	Partial results are returned with the error.
+*/
+func ParseIptc(data []byte) (*Iptc, error) {
+	iptc := &Iptc{}
+	utf8Set := false
+	for pos := 0; pos < len(data); {
+		if data[pos] != iptcTagMarker {
+			// Some writers pad the records with zeros
+			if data[pos] == 0 {
+				pos++
+				continue
+			}
+			return iptc, fmt.Errorf("iptc tag marker expected at offset %d found %X", pos, data[pos])
+		}
+		if pos+5 > len(data) {
+			return iptc, fmt.Errorf("iptc dataset at offset %d is truncated", pos)
+		}
+		start := pos
+		dataset := binary.BigEndian.Uint16(data[pos+1:])
+		length := int(binary.BigEndian.Uint16(data[pos+3:]))
+		pos = pos + 5
+		if length&0x8000 != 0 {
+			// Extended dataset. The low bits are the size of the length field
+			lenLen := length & 0x7FFF
+			if lenLen > 4 || pos+lenLen > len(data) {
+				return iptc, fmt.Errorf("iptc dataset %d:%d has an invalid extended length", dataset>>8, dataset&0xFF)
+			}
+			length = 0
+			for _, b := range data[pos : pos+lenLen] {
+				length = length<<8 | int(b)
+			}
+			pos = pos + lenLen
+		}
+		if pos+length > len(data) {
+			return iptc, fmt.Errorf("iptc dataset %d:%d at offset %d has length %d past the end of the data", dataset>>8, dataset&0xFF, start, length)
+		}
+		value := data[pos : pos+length]
+		pos = pos + length
+
+		text := func() string {
+			if utf8Set || utf8.Valid(value) {
+				return strings.TrimSpace(string(value))
+			}
+			// Latin-1 maps directly to the first 256 code points
+			runes := make([]rune, len(value))
+			for i, b := range value {
+				runes[i] = rune(b)
+			}
+			return strings.TrimSpace(string(runes))
+		}
+		switch dataset {
+		case IptcCodedCharacterSet:
+			utf8Set = string(value) == iptcUTF8
+		case IptcKeywords:
+			iptc.Keywords = append(iptc.Keywords, text())
+		case IptcDateCreated:
+			iptc.DateCreated = text()
+		case IptcTimeCreated:
+			iptc.TimeCreated = text()
+		case IptcDigitalCreationDate:
+			iptc.DigitalCreationDate = text()
+		case IptcDigitalCreationTime:
+			iptc.DigitalCreationTime = text()
+		case IptcByLine:
+			iptc.ByLine = append(iptc.ByLine, text())
+		case IptcCaption:
+			iptc.Caption = text()
+		}
+	}
+	return iptc, nil
+}
+
+/*
+Find a resource in Photoshop image resource blocks.
+
+	Each block is '8BIM', a 2 byte id, a Pascal name padded to an even length and a 4 byte size
+	followed by the data padded to an even length.
+*/
+func findPhotoshopResource(data []byte, id uint16) ([]byte, error) {
+	for pos := 0; pos+4 <= len(data); {
+		if string(data[pos:pos+4]) != "8BIM" {
+			return nil, fmt.Errorf("photoshop resource signature expected at offset %d", pos)
+		}
+		if pos+7 > len(data) {
+			break
+		}
+		resourceId := binary.BigEndian.Uint16(data[pos+4:])
+		nameLen := int(data[pos+6])
+		// The length byte and the name are padded to an even length
+		pos = pos + 6 + nameLen + 1 + (nameLen+1)&1
+		if pos+4 > len(data) {
+			break
+		}
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		pos = pos + 4
+		if size < 0 || pos+size > len(data) {
+			return nil, fmt.Errorf("photoshop resource %04X size %d is past the end of the data", resourceId, size)
+		}
+		if resourceId == id {
+			return data[pos : pos+size], nil
+		}
+		pos = pos + size + size&1
+	}
+	return nil, nil
+}
+
+/*
+Read the IPTC records from the APP13 'Photoshop 3.0' segments.
+*/
+func (p *image) readJpegIptc(segments []*JpegSegment) {
+	for _, s := range segments {
+		if !s.IsPhotoshop() {
+			continue
+		}
+		// The identifier is zero terminated
+		identLen := uint32(len(JpegPhotoshopIdent) + 1)
+		if s.Length <= identLen {
+			continue
+		}
+		w := p.walker.Clone()
+		resources := w.Pos(s.DataOffset() + identLen).Bytes(s.Length - identLen)
+		if w.Err() != nil {
+			if p.debug {
+				p.logOutput(fmt.Sprintf("DEBUG: photoshop segment at offset %d: %s", s.Offset, w.Err().Error()), "")
+			}
+			continue
+		}
+		records, err := findPhotoshopResource(resources, PhotoshopResourceIptc)
+		if err == nil && records != nil {
+			p.iptc, err = ParseIptc(records)
+		}
+		if err != nil && p.debug {
+			p.logOutput(fmt.Sprintf("DEBUG: photoshop segment at offset %d: %s", s.Offset, err.Error()), "")
+		}
+		if p.iptc != nil {
+			return
+		}
+	}
+}
+
+/*
+The IPTC metadata. Nil if the image does not have any.
+*/
+func (p *image) Iptc() *Iptc {
+	return p.iptc
+}
+
+/*
+The date and time from the IPTC metadata. Nil if the image has no IPTC date.
+*/
+func (p *image) IptcDateTime() *FileDateTime {
+	if p.iptc == nil {
+		return nil
+	}
+	return p.iptc.DateTime()
+}
+
+/*
+DateCreated and TimeCreated or, if there is no DateCreated, the digital creation date and time.
+
+	Nil if there is no date that can be read. The time and its UTC offset are optional. Dates before 1970
+	(scanned slides and prints) are allowed.
+*/
+func (i *Iptc) DateTime() *FileDateTime {
+	date, tod := i.DateCreated, i.TimeCreated
+	if date == "" {
+		date, tod = i.DigitalCreationDate, i.DigitalCreationTime
+	}
+	if len(tod) < 6 {
+		tod = "000000"
+	}
+	// Keep the wall clock time as written, like the EXIF dates
+	t, err := time.Parse("20060102150405", date+tod[:6])
+	if err != nil {
+		return nil
+	}
+	dt := NewFileDateTimeFromTime(t)
+	dt.src = SrcIptc
+	dt.hasOffset = false
+	if len(tod) >= 11 {
+		dt.setOffset(tod[6:11])
+	}
+	return dt
+}
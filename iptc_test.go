@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testIptcDataset(dataset uint16, value string) []byte {
+	b := []byte{iptcTagMarker}
+	b = binary.BigEndian.AppendUint16(b, dataset)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	return append(b, []byte(value)...)
+}
+
+func testPhotoshopResource(id uint16, name string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint16([]byte("8BIM"), id)
+	b = append(b, byte(len(name)))
+	b = append(b, []byte(name)...)
+	if (len(name)+1)&1 == 1 {
+		b = append(b, 0)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	b = append(b, data...)
+	if len(data)&1 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+/*
+A jpeg with an APP13 Photoshop segment holding records and, if tiff is not nil, an APP1 Exif segment.
+*/
+func buildTestIptcJpeg(records []byte, tiff []byte) []byte {
+	jpg := []byte{0xFF, 0xD8}
+	if tiff != nil {
+		jpg = wrapTestExifJpeg(tiff)
+		jpg = jpg[:len(jpg)-2]
+	}
+	data := []byte(JpegPhotoshopIdent + "\x00")
+	// A resource before the IPTC with an odd name and size so the padding is used
+	data = append(data, testPhotoshopResource(0x03ED, "res", []byte{1, 2, 3})...)
+	data = append(data, testPhotoshopResource(PhotoshopResourceIptc, "", records)...)
+	jpg = append(jpg, 0xFF, 0xED)
+	jpg = binary.BigEndian.AppendUint16(jpg, uint16(2+len(data)))
+	jpg = append(jpg, data...)
+	return append(jpg, 0xFF, 0xD9)
+}
+
+func TestParseIptc(t *testing.T) {
+	records := testIptcDataset(0x0200, "\x00\x04") // Record version
+	records = append(records, testIptcDataset(IptcDateCreated, "19650704")...)
+	records = append(records, testIptcDataset(IptcTimeCreated, "183000+0000")...)
+	records = append(records, testIptcDataset(IptcCaption, "Caf\xe9 on the beach")...) // Latin-1
+	records = append(records, testIptcDataset(IptcKeywords, "slide")...)
+	records = append(records, testIptcDataset(IptcKeywords, "holiday")...)
+	records = append(records, testIptcDataset(IptcByLine, "A. Photographer")...)
+	// Extended length. 2 bytes of length
+	records = append(records, iptcTagMarker, 0x02, 0x50, 0x80, 0x02, 0x00, 0x03)
+	records = append(records, []byte("Two")...)
+	records = append(records, 0, 0)
+
+	iptc, err := ParseIptc(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, iptc.Caption, "Café on the beach")
+	AssertEquals(t, strings.Join(iptc.Keywords, ","), "slide,holiday")
+	AssertEquals(t, strings.Join(iptc.ByLine, ","), "A. Photographer,Two")
+	// Slides can be from before 1970
+	dt := iptc.DateTime()
+	if dt == nil || dt.Spec() != "19650704183000" || dt.src != SrcIptc {
+		t.Fatalf("Date should be 19650704183000. Actual %v", dt)
+	}
+
+	// UTF-8 from the coded character set
+	iptc, err = ParseIptc(append(testIptcDataset(IptcCodedCharacterSet, iptcUTF8), testIptcDataset(IptcDigitalCreationDate, "20010203")...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, iptc.DateTime().Spec(), "20010203000000")
+
+	// Datasets before the error are returned
+	iptc, err = ParseIptc(append(testIptcDataset(IptcCaption, "Caption"), iptcTagMarker, 0x02, 0x19, 0x00, 0x10, 'x'))
+	if err == nil || iptc.Caption != "Caption" {
+		t.Fatalf("Should fail with the caption read. Actual %v %v", iptc, err)
+	}
+	AssertEquals(t, err.Error(), "iptc dataset 2:25 at offset 12 has length 16 past the end of the data")
+}
+
+func TestIptcDateTime(t *testing.T) {
+	records := append(testIptcDataset(IptcDateCreated, "19721225"), testIptcDataset(IptcTimeCreated, "101112")...)
+	records = append(records, testIptcDataset(IptcCaption, "Christmas")...)
+	dateTime := []byte("2020:01:02 03:04:05\x00")
+	dir := t.TempDir()
+	createDataFile(t, buildTestIptcJpeg(records, nil), filepath.Join(dir, "slide.jpg"))
+	createDataFile(t, buildTestIptcJpeg(records, buildTestTiff(true, []testTiffEntry{{tag: 306, format: FormatString, count: uint32(len(dateTime)), value: dateTime}})), filepath.Join(dir, "exif.jpg"))
+
+	im, _ := NewImage(filepath.Join(dir, "slide.jpg"), false, nil, logTest)
+	if im == nil || im.Iptc() == nil {
+		t.Fatal("IPTC should be read")
+	}
+	AssertEquals(t, im.Iptc().Caption, "Christmas")
+
+	d := &Dict{config: newTestConfig(dir)}
+	dt := d.GetFileDateTime("slide.jpg", NewGroup("", dir, ""), logTest)
+	if dt.src != SrcModTime {
+		t.Fatalf("IPTC dates are not used by default. Actual %s src %d", dt.Spec(), dt.src)
+	}
+	d.config.IptcDates = true
+	dt = d.GetFileDateTime("slide.jpg", NewGroup("", dir, ""), logTest)
+	if dt.src != SrcIptc || dt.Spec() != "19721225101112" {
+		t.Fatalf("Date should be from IPTC. Actual %s src %d", dt.Spec(), dt.src)
+	}
+	// EXIF is preferred
+	dt = d.GetFileDateTime("exif.jpg", NewGroup("", dir, ""), logTest)
+	if dt.src != SrcDateTime || dt.Spec() != "20200102030405" {
+		t.Fatalf("Date should be from EXIF. Actual %s src %d", dt.Spec(), dt.src)
+	}
+}
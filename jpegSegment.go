@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const JpegMarkerSOI uint16 = 0xFFD8
+const JpegMarkerEOI uint16 = 0xFFD9
+const JpegMarkerSOS uint16 = 0xFFDA
+const JpegMarkerAPP0 uint16 = 0xFFE0
+const JpegMarkerAPP1 uint16 = 0xFFE1
+const JpegMarkerCOM uint16 = 0xFFFE
+
+// Longest APPn identifier we bother to read. The XMP extension identifier is 35 bytes.
+const jpegMaxIdentLen = 40
+
+var jpegMarkerNames = map[uint16]string{
+	0xFFC0: "SOF0",
+	0xFFC1: "SOF1",
+	0xFFC2: "SOF2",
+	0xFFC3: "SOF3",
+	0xFFC4: "DHT",
+	0xFFC5: "SOF5",
+	0xFFC6: "SOF6",
+	0xFFC7: "SOF7",
+	0xFFC9: "SOF9",
+	0xFFCA: "SOF10",
+	0xFFCB: "SOF11",
+	0xFFCC: "DAC",
+	0xFFCD: "SOF13",
+	0xFFCE: "SOF14",
+	0xFFCF: "SOF15",
+	0xFFD8: "SOI",
+	0xFFD9: "EOI",
+	0xFFDA: "SOS",
+	0xFFDB: "DQT",
+	0xFFDC: "DNL",
+	0xFFDD: "DRI",
+	0xFFDE: "DHP",
+	0xFFDF: "EXP",
+	0xFFFE: "COM",
+}
+
+/*
+A single JPEG marker segment.
+
+	Offset is the position of the FFxx marker in the file.
+	Length is the size of the segment data. It does NOT include the marker or the 2 length bytes.
+	Ident is the zero terminated identifier at the start of APPn data. For example 'Exif' or 'JFIF'.
+*/
+type JpegSegment struct {
+	Marker uint16
+	Offset uint32
+	Length uint32
+	Ident  string
+}
+
+func (s *JpegSegment) DataOffset() uint32 {
+	return s.Offset + 4
+}
+
+func (s *JpegSegment) IsApp(n int) bool {
+	return s.Marker == JpegMarkerAPP0+uint16(n)
+}
+
+func (s *JpegSegment) IsExif() bool {
+	return s.IsApp(1) && s.Ident == "Exif"
+}
+
+func (s *JpegSegment) IsXmp() bool {
+	return s.IsApp(1) && s.Ident == JpegXmpIdent
+}
+
+func (s *JpegSegment) IsPhotoshop() bool {
+	return s.IsApp(13) && s.Ident == JpegPhotoshopIdent
+}
+
+func (s *JpegSegment) Name() string {
+	if s.Marker >= JpegMarkerAPP0 && s.Marker <= JpegMarkerAPP0+15 {
+		return fmt.Sprintf("APP%d", s.Marker-JpegMarkerAPP0)
+	}
+	if s.Marker >= 0xFFD0 && s.Marker <= 0xFFD7 {
+		return fmt.Sprintf("RST%d", s.Marker-0xFFD0)
+	}
+	n, ok := jpegMarkerNames[s.Marker]
+	if ok {
+		return n
+	}
+	return fmt.Sprintf("%X", s.Marker)
+}
+
+func (s *JpegSegment) String() string {
+	if s.Ident != "" {
+		return fmt.Sprintf("%s(%s)@%d:%d", s.Name(), s.Ident, s.Offset, s.Length)
+	}
+	return fmt.Sprintf("%s@%d:%d", s.Name(), s.Offset, s.Length)
+}
+
+func jpegSegmentsString(segments []*JpegSegment) string {
+	var line bytes.Buffer
+	line.WriteRune('[')
+	for i, s := range segments {
+		line.WriteString(s.String())
+		if i < (len(segments) - 1) {
+			line.WriteRune(',')
+		}
+	}
+	line.WriteRune(']')
+	return line.String()
+}
+
+/*
+Walk the JPEG marker segments from SOI up to and including SOS (or EOI).
+
+After SOS the file is entropy coded image data so there is no more metadata to find.
+Segments found before an error are returned with it.
+*/
+func ScanJpegSegments(walker *Walker) ([]*JpegSegment, error) {
+	segments := []*JpegSegment{}
+	w := walker.Clone()
+	w.littleE = false // Jpeg markers and lengths are always Big Endian
+	pos := uint32(OfsSOI)
+
+	stopped := func() error {
+		return fmt.Errorf("jpeg segment scan stopped: %w", w.Err())
+	}
+
+	soi := uint16(w.Pos(pos).bytesToUintBE(w.Bytes(2)))
+	if w.Err() != nil {
+		return segments, stopped()
+	}
+	if soi != JpegMarkerSOI {
+		return segments, fmt.Errorf("jpeg marker 'FFD8' is missing (Offset %d) found %X", OfsSOI, soi)
+	}
+	segments = append(segments, &JpegSegment{Marker: soi, Offset: pos})
+	pos = pos + 2
+
+	for {
+		if w.Pos(pos).Advance(1) != 0xFF {
+			if w.Err() != nil {
+				return segments, stopped()
+			}
+			return segments, fmt.Errorf("jpeg marker expected at offset %d found %s", pos, w.Pos(pos).Hex(w.Bytes(1), "0x"))
+		}
+		// Any number of 0xFF fill bytes may precede a marker
+		m := w.Advance(1)
+		for m == 0xFF {
+			pos++
+			m = w.Advance(1)
+		}
+		if w.Err() != nil {
+			return segments, stopped()
+		}
+		seg := &JpegSegment{Marker: 0xFF00 | uint16(m), Offset: pos}
+		segments = append(segments, seg)
+		if seg.Marker == JpegMarkerEOI {
+			return segments, nil
+		}
+		if m == 0x01 || (m >= 0xD0 && m <= 0xD7) {
+			// Stand alone markers have no length
+			pos = pos + 2
+			continue
+		}
+		segLen := uint32(w.bytesToUintBE(w.Bytes(2)))
+		if w.Err() != nil {
+			return segments, stopped()
+		}
+		if segLen < 2 {
+			return segments, fmt.Errorf("jpeg segment %s at offset %d has invalid length %d", seg.Name(), pos, segLen)
+		}
+		seg.Length = segLen - 2
+		if seg.Marker >= JpegMarkerAPP0 && seg.Marker <= JpegMarkerAPP0+15 {
+			seg.Ident = w.Zstring(int(min(seg.Length, jpegMaxIdentLen)))
+			if w.Err() != nil {
+				return segments, stopped()
+			}
+		}
+		if seg.Marker == JpegMarkerSOS {
+			return segments, nil
+		}
+		pos = pos + 2 + segLen
+	}
+}
@@ -12,6 +12,8 @@ var config *ThumbnailInfo
 var dict *Dict
 var execOut *ExecFile
 var verboseArg = false
+var pruneArg = false
+var dryRunArg = false
 
 func main() {
 	if len(os.Args) < 2 {
@@ -26,6 +28,14 @@ func main() {
 			os.Stdout.WriteString(" ## Verbose flag found:")
 			os.Stdout.WriteString("\n")
 		}
+		if lca == "prune" || lca == "-prune" {
+			pruneArg = true
+		}
+		if lca == "--dry-run" || lca == "-dry-run" {
+			dryRunArg = true
+			os.Stdout.WriteString(" ## Dry run flag found:")
+			os.Stdout.WriteString("\n")
+		}
 	}
 
 	content, err := os.ReadFile(os.Args[1])
@@ -45,12 +55,27 @@ func main() {
 	}
 	defer config.Close()
 
-	execOut, err = NewExecOut(config.ThumbNailsExecFile, config.logger.Log, head, tail, config.Verbose)
-	if err != nil {
-		config.logger.Log(fmt.Sprintf("File:%s Error:%s", config.ThumbNailsExecFile, err), "Failed to open exec file:")
-		os.Exit(1)
+	if pruneArg {
+		dict = NewDict(config)
+		timer := NewTimedProcess("Time to Prune")
+		counts := dict.Prune(dryRunArg, config.logger.Log)
+		timer.End()
+		config.logger.Log(timer.String(), "")
+		LogPruneCounts(counts, func(s, prefix string) {
+			config.logger.Log(s, prefix)
+			os.Stdout.WriteString(fmt.Sprintf(" ## %s %s\n", prefix, s))
+		})
+		return
+	}
+
+	if config.Renderer == RendererScript {
+		execOut, err = NewExecOut(config.ThumbNailsExecFile, config.logger.Log, head, tail, config.Verbose)
+		if err != nil {
+			config.logger.Log(fmt.Sprintf("File:%s Error:%s", config.ThumbNailsExecFile, err), "Failed to open exec file:")
+			os.Exit(1)
+		}
+		defer execOut.Close()
 	}
-	defer execOut.Close()
 	dict = NewDict(config)
 
 	timer := NewTimedProcess("Time to Populate")
@@ -58,10 +83,39 @@ func main() {
 	timer.End()
 
 	config.logger.Log(timer.String(), "")
+	if config.CheckStale {
+		config.logger.Log(fmt.Sprintf("Files:%d Missing:%d Stale:%d", dict.fileCount, dict.tnMissingCount-dict.tnStaleCount, dict.tnStaleCount), "Thumbnails:")
+	}
 	if config.Verbose {
 		dict.LogGroups("Group")
 		dict.LogDict()
 	}
+	if config.Renderer == RendererNative {
+		timer = NewTimedProcess("Time Render Thumbnail(s)")
+		dict.RenderMissingTn(timer, config.logger.Log)
+		timer.End()
+		config.logger.Log(timer.String(), "")
+		saveIndex()
+		if dict.LogFailures(config.logger.Log) > 0 {
+			config.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if config.Renderer == RendererExec {
+		timer = NewTimedProcess("Time Exec Thumbnail(s)")
+		dict.ExecMissingTn(timer, config.logger.Log)
+		timer.End()
+		config.logger.Log(timer.String(), "")
+		saveIndex()
+		if dict.LogFailures(config.logger.Log) > 0 {
+			config.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
 	timer = NewTimedProcess("Time Create Script(s)")
 	todo := dict.CountRequired()
 	for todo > 0 {
@@ -79,6 +133,7 @@ func main() {
 	}
 	timer.End()
 	config.logger.Log(timer.String(),"")
+	saveIndex()
 	execOut.listExecFiles(func(s string) {
 		config.logger.Log("Exec CHMOD:"+s, "")
 		os.Chmod(s, 0775)
@@ -109,6 +164,13 @@ func tail(ex *ExecFile) string {
 	return buf.String()
 }
 
+func saveIndex() {
+	err := dict.SaveIndex()
+	if err != nil {
+		config.logger.Log(err.Error(), "Failed to save scan index:")
+	}
+}
+
 func execLog(s string) {
 	if config.Verbose {
 		config.logger.Log(s, "Exec:")
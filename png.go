@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+
+// Text keywords that hold the time the image was created
+var pngCreationTimeKeywords = []string{"Creation Time", "CreationTime", "date:create"}
+
+// Layouts seen in PNG and video creation times. The PNG spec suggests RFC 1123.
+var creationTimeLayouts = []string{
+	time.RFC1123,
+	time.RFC1123Z,
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	time.ANSIC,
+	"2006:01:02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// Longest text we read from a tEXt, zTXt or iTXt chunk. Creation times are short and XMP in iTXt can be large.
+const pngMaxTextLen = 64 * 1024
+
+/*
+A single PNG chunk.
+
+	Offset is the position of the length field in the file.
+	Length is the size of the chunk data. It does NOT include the length, type or CRC.
+*/
+type PngChunk struct {
+	Type   string
+	Offset uint32
+	Length uint32
+}
+
+func (c *PngChunk) DataOffset() uint32 {
+	return c.Offset + 8
+}
+
+func (c *PngChunk) String() string {
+	return fmt.Sprintf("%s@%d:%d", c.Type, c.Offset, c.Length)
+}
+
+func isPngFile(walker *Walker) bool {
+	w := walker.Clone()
+	return bytes.Equal(w.Pos(0).Bytes(uint32(len(pngSignature))), pngSignature) && w.Err() == nil
+}
+
+/*
+Walk the PNG chunks from the signature up to and including IEND.
+
+	Chunks found before an error are returned with it.
+*/
+func ScanPngChunks(walker *Walker) ([]*PngChunk, error) {
+	chunks := []*PngChunk{}
+	w := walker.Clone()
+	w.littleE = false // PNG is always Big Endian
+	fileEnd := uint64(w.Len())
+	pos := uint32(len(pngSignature))
+	for uint64(pos) < fileEnd {
+		length := w.Pos(pos).BytesToUint(w.Bytes(4))
+		chunk := &PngChunk{Type: string(w.Bytes(4)), Offset: pos, Length: uint32(length)}
+		if w.Err() != nil {
+			return chunks, fmt.Errorf("png chunk scan stopped: %w", w.Err())
+		}
+		// length + type + data + crc
+		if uint64(pos)+12+length > fileEnd {
+			return chunks, fmt.Errorf("png chunk %s at offset %d has length %d past the end of the file", chunk.Type, pos, length)
+		}
+		chunks = append(chunks, chunk)
+		if chunk.Type == "IEND" {
+			return chunks, nil
+		}
+		pos = pos + 12 + chunk.Length
+	}
+	return chunks, nil
+}
+
+/*
+Read the keyword and text from a tEXt, zTXt or iTXt chunk. Compressed text is inflated.
+*/
+func readPngText(walker *Walker, chunk *PngChunk) (string, string, error) {
+	w := walker.Clone()
+	data := w.Pos(chunk.DataOffset()).Bytes(min(chunk.Length, pngMaxTextLen))
+	if w.Err() != nil {
+		return "", "", w.Err()
+	}
+	keyword, rest, found := bytes.Cut(data, []byte{0})
+	if !found {
+		return "", "", fmt.Errorf("png %s chunk at offset %d has no keyword", chunk.Type, chunk.Offset)
+	}
+	compressed := false
+	switch chunk.Type {
+	case "zTXt":
+		// Compression method then the compressed text
+		if len(rest) < 1 {
+			return "", "", fmt.Errorf("png zTXt chunk at offset %d is too short", chunk.Offset)
+		}
+		rest = rest[1:]
+		compressed = true
+	case "iTXt":
+		// Compression flag, compression method, language tag\0, translated keyword\0 then the text
+		if len(rest) < 2 {
+			return "", "", fmt.Errorf("png iTXt chunk at offset %d is too short", chunk.Offset)
+		}
+		compressed = rest[0] == 1
+		parts := bytes.SplitN(rest[2:], []byte{0}, 3)
+		if len(parts) < 3 {
+			return "", "", fmt.Errorf("png iTXt chunk at offset %d is too short", chunk.Offset)
+		}
+		rest = parts[2]
+	}
+	if compressed {
+		zr, err := zlib.NewReader(bytes.NewReader(rest))
+		if err != nil {
+			return "", "", fmt.Errorf("png %s chunk at offset %d: %w", chunk.Type, chunk.Offset, err)
+		}
+		rest, err = io.ReadAll(io.LimitReader(zr, pngMaxTextLen))
+		if err != nil {
+			return "", "", fmt.Errorf("png %s chunk at offset %d: %w", chunk.Type, chunk.Offset, err)
+		}
+	}
+	return string(keyword), string(rest), nil
+}
+
+/*
+Find the eXIf chunk in a PNG and set the TIFF base and size from it. Text chunks are kept in texts.
+
+	An XMP packet in an iTXt chunk is read into xmp.
+	Returns an error wrapping ErrNoExif if there is no eXIf chunk.
+*/
+func (p *image) findPngExif() error {
+	chunks, scanErr := ScanPngChunks(p.walker)
+	if p.debug {
+		p.logOutput(fmt.Sprintf("DEBUG: Chunks%s", pngChunksString(chunks)), "")
+		if scanErr != nil {
+			p.logOutput(fmt.Sprintf("DEBUG: %s", scanErr.Error()), "")
+		}
+	}
+	var exifChunk *PngChunk
+	for _, c := range chunks {
+		switch c.Type {
+		case "eXIf":
+			if exifChunk == nil {
+				exifChunk = c
+			}
+		case "tEXt", "zTXt", "iTXt":
+			keyword, text, err := readPngText(p.walker, c)
+			if err != nil {
+				if p.debug {
+					p.logOutput(fmt.Sprintf("DEBUG: %s", err.Error()), "")
+				}
+				continue
+			}
+			p.texts[keyword] = text
+			if keyword == PngXmpKeyword {
+				p.readXmp([]byte(text))
+			}
+		}
+	}
+	if exifChunk == nil {
+		if scanErr != nil {
+			return fmt.Errorf("png %w. Chunks%s. Error:%s", ErrNoExif, pngChunksString(chunks), scanErr.Error())
+		}
+		return fmt.Errorf("png %w. Chunks%s", ErrNoExif, pngChunksString(chunks))
+	}
+	p.tiffBase, p.tiffSize = exifPayload(p.walker, exifChunk.DataOffset(), exifChunk.Length)
+	return nil
+}
+
+/*
+The creation time from the PNG text chunks. Nil if there is not one that can be read.
+*/
+func (p *image) pngCreationTime() *FileDateTime {
+	for _, k := range pngCreationTimeKeywords {
+		text, ok := p.texts[k]
+		if !ok {
+			continue
+		}
+		dt := parseCreationTime(text, SrcCreationTime)
+		if dt != nil {
+			return dt
+		}
+	}
+	return nil
+}
+
+func parseCreationTime(text string, src int) *FileDateTime {
+	text = string(bytes.TrimSpace([]byte(text)))
+	for _, layout := range creationTimeLayouts {
+		t, err := time.Parse(layout, text)
+		if err == nil {
+			// Keep the wall clock time as written, like the EXIF dates
+			dt := NewFileDateTimeFromTime(t)
+			dt.src = src
+			dt.hasOffset = layoutHasOffset(layout)
+			return dt
+		}
+	}
+	dt, err := NewFileDateTimeFromSpec(text, src)
+	if err != nil {
+		return nil
+	}
+	return dt
+}
+
+/*
+True if the layout has a numeric UTC offset. Other layouts are parsed as UTC so the offset is not known.
+*/
+func layoutHasOffset(layout string) bool {
+	return strings.Contains(layout, "-07") || strings.Contains(layout, "Z07")
+}
+
+func pngChunksString(chunks []*PngChunk) string {
+	var line bytes.Buffer
+	line.WriteRune('[')
+	for i, c := range chunks {
+		line.WriteString(c.String())
+		if i < (len(chunks) - 1) {
+			line.WriteRune(',')
+		}
+	}
+	line.WriteRune(']')
+	return line.String()
+}
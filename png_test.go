@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func testPngChunk(chunkType string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	b = append(b, []byte(chunkType)...)
+	b = append(b, data...)
+	return append(b, 0, 0, 0, 0) // The CRC is not checked
+}
+
+func testZlib(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(s))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildTestPng(chunks ...[]byte) []byte {
+	png := append([]byte{}, pngSignature...)
+	png = append(png, testPngChunk("IHDR", make([]byte, 13))...)
+	for _, c := range chunks {
+		png = append(png, c...)
+	}
+	png = append(png, testPngChunk("IDAT", []byte{1, 2, 3})...)
+	return append(png, testPngChunk("IEND", nil)...)
+}
+
+func TestPngExif(t *testing.T) {
+	dateTime := []byte("2020:02:29 23:59:58\x00")
+	tiff := buildTestTiff(true, []testTiffEntry{{tag: 36867, format: FormatString, count: uint32(len(dateTime)), value: dateTime}})
+	dir := t.TempDir()
+	d := &Dict{config: newTestConfig(dir)}
+	for name, exif := range map[string][]byte{
+		"plain.png":  tiff,
+		"prefix.png": append([]byte("Exif\x00\x00"), tiff...),
+	} {
+		createDataFile(t, buildTestPng(testPngChunk("tEXt", []byte("Creation Time\x002001:01:01 01:01:01")), testPngChunk("eXIf", exif)), filepath.Join(dir, name))
+		im, err := NewImage(filepath.Join(dir, name), false, nil, logTest)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		AssertEquals(t, im.FileType(), ImageTypePng)
+		AssertEquals(t, im.Output(), "DateTimeOriginal=2020:02:29 23:59:58\n")
+		// EXIF is preferred to the creation time
+		dt := d.GetFileDateTime(name, NewGroup("", dir, ""), logTest)
+		if dt.src != SrcDateTimeOriginal || dt.Spec() != "20200229235958" {
+			t.Fatalf("%s: Date should be from DateTimeOriginal. Actual %s src %d", name, dt.Spec(), dt.src)
+		}
+	}
+}
+
+func TestPngCreationTime(t *testing.T) {
+	dir := t.TempDir()
+	d := &Dict{config: newTestConfig(dir)}
+	tests := []struct {
+		name  string
+		chunk []byte
+		spec  string
+	}{
+		{"text.png", testPngChunk("tEXt", []byte("Creation Time\x00Sat, 29 Feb 2020 10:11:12 GMT")), "20200229101112"},
+		{"ztxt.png", testPngChunk("zTXt", append([]byte("Creation Time\x00\x00"), testZlib(t, "2019:07:08 09:10:11")...)), "20190708091011"},
+		{"itxt.png", testPngChunk("iTXt", []byte("Creation Time\x00\x00\x00en\x00\x002018-01-02T03:04:05+01:00")), "20180102030405"},
+		{"itxtz.png", testPngChunk("iTXt", append([]byte("date:create\x00\x01\x00\x00\x00"), testZlib(t, "2017-06-07T08:09:10.123456+00:00")...)), "20170607080910"},
+	}
+	for _, tc := range tests {
+		createDataFile(t, buildTestPng(testPngChunk("tEXt", []byte("Software\x00Screenshot tool")), tc.chunk), filepath.Join(dir, tc.name))
+		im, err := NewImage(filepath.Join(dir, tc.name), false, nil, logTest)
+		if !errors.Is(err, ErrNoExif) || im == nil {
+			t.Fatalf("%s: should return the image and ErrNoExif. Actual %v", tc.name, err)
+		}
+		AssertEquals(t, im.Texts()["Software"], "Screenshot tool")
+		dt := d.GetFileDateTime(tc.name, NewGroup("", dir, ""), logTest)
+		if dt.src != SrcCreationTime || dt.Spec() != tc.spec {
+			t.Fatalf("%s: Date should be %s from the creation time. Actual %s src %d", tc.name, tc.spec, dt.Spec(), dt.src)
+		}
+	}
+
+	// No metadata at all falls back to the file name
+	createDataFile(t, buildTestPng(), filepath.Join(dir, "Screenshot_20160102-030405.png"))
+	dt := d.GetFileDateTime("Screenshot_20160102-030405.png", NewGroup("", dir, ""), logTest)
+	if dt.src != SrcFileName || dt.Spec() != "20160102030405" {
+		t.Fatalf("Date should be from the file name. Actual %s src %d", dt.Spec(), dt.src)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type PruneCount struct {
+	checked  int // Thumbnail files looked at
+	orphaned int // Thumbnails without a source image
+	deleted  int
+	failed   int // Could not be deleted
+}
+
+func (pc *PruneCount) String() string {
+	return fmt.Sprintf("Checked:%d Orphaned:%d Deleted:%d Failed:%d", pc.checked, pc.orphaned, pc.deleted, pc.failed)
+}
+
+/*
+Find (and unless dryRun delete) thumbnails whose source image no longer exists.
+
+	Walks ThumbNailsRoot/<user>/<imagePath> for each configured user image path.
+	The timestamp prefix and ThumbNailFileSuffix are stripped from each thumbnail name (as NewFileCache does)
+	to get the source image name, which is then looked for in the same relative directory under the user image root.
+	If the source directory for an image path does not exist (for example an unmounted drive) it is skipped
+	so we never delete everything.
+
+	Returns the counts by user.
+*/
+func (d *Dict) Prune(dryRun bool, logFn func(string, string)) map[string]*PruneCount {
+	counts := map[string]*PruneCount{}
+	sourceDirs := map[string]map[string]bool{}
+
+	path := d.config.Next()
+	for path != nil {
+		pc, ok := counts[path.user]
+		if !ok {
+			pc = &PruneCount{}
+			counts[path.user] = pc
+		}
+		tnRoot := filepath.Join(d.tnPath, path.user, path.iPath)
+		if !dirExists(path.Path()) {
+			logFn(fmt.Sprintf("User:%s Path:%s", path.user, path.Path()), "Prune skipped. Source path not found:")
+			path = d.config.Next()
+			continue
+		}
+		if !dirExists(tnRoot) {
+			path = d.config.Next()
+			continue
+		}
+		filepath.WalkDir(tnRoot, func(tnFile string, de fs.DirEntry, err error) error {
+			if err != nil {
+				logFn(fmt.Sprintf("Path:%s Error:%s", tnFile, err.Error()), "Prune:")
+				return nil
+			}
+			if de.IsDir() {
+				return nil
+			}
+			fn := de.Name()
+			if len(fn) <= d.tnPrefixLen+d.tnSuffixLen || !strings.HasSuffix(fn, d.config.ThumbNailFileSuffix) {
+				return nil
+			}
+			pc.checked++
+			rel, _ := filepath.Rel(tnRoot, filepath.Dir(tnFile))
+			sourceDir := filepath.Join(path.Path(), rel)
+			names, ok := sourceDirs[sourceDir]
+			if !ok {
+				names = readDirNames(sourceDir)
+				sourceDirs[sourceDir] = names
+			}
+			if names[fn[d.tnPrefixLen:len(fn)-d.tnSuffixLen]] {
+				return nil
+			}
+			pc.orphaned++
+			if dryRun {
+				logFn(tnFile, "Orphan (dry run):")
+				return nil
+			}
+			err = os.Remove(tnFile)
+			if err != nil {
+				pc.failed++
+				logFn(fmt.Sprintf("File:%s Error:%s", tnFile, err.Error()), "Orphan delete failed:")
+			} else {
+				pc.deleted++
+				logFn(tnFile, "Orphan deleted:")
+			}
+			return nil
+		})
+		path = d.config.Next()
+	}
+	return counts
+}
+
+func LogPruneCounts(counts map[string]*PruneCount, logFn func(string, string)) {
+	users := make([]string, 0, len(counts))
+	for u := range counts {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	for _, u := range users {
+		logFn(fmt.Sprintf("User:%s %s", u, counts[u].String()), "Prune:")
+	}
+}
+
+/*
+The names of the files in dir. Empty if it does not exist.
+*/
+func readDirNames(dir string) map[string]bool {
+	names := map[string]bool{}
+	raw, err := os.ReadDir(dir)
+	if err != nil {
+		return names
+	}
+	for _, fi := range raw {
+		if !fi.IsDir() {
+			names[fi.Name()] = true
+		}
+	}
+	return names
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestConfig(root string) *ThumbnailInfo {
+	return &ThumbnailInfo{
+		ThumbNailsRoot:      filepath.Join(root, "tn"),
+		ThumbNailTimeStamp:  "%y_%m_%d_%H_%M_%S_",
+		ThumbNailFileSuffix: ".jpg",
+		Resources: map[string]*Users{
+			"bob": {ImageRoot: filepath.Join(root, "orig"), ImagePaths: []string{"Phone", "Unmounted"}},
+		},
+		pathList: make([]*UserPathInfo, 0),
+		logger:   newLogfile("", "", false),
+	}
+}
+
+func touchFile(t *testing.T, path string) {
+	err := os.MkdirAll(filepath.Dir(path), 0775)
+	if err == nil {
+		err = os.WriteFile(path, []byte{}, 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	root := t.TempDir()
+	touchFile(t, filepath.Join(root, "orig", "bob", "Phone", "a.jpg"))
+	touchFile(t, filepath.Join(root, "orig", "bob", "Phone", "sub", "b.jpg"))
+	keep := []string{
+		filepath.Join(root, "tn", "bob", "Phone", "2016_11_06_11_29_18_a.jpg.jpg"),
+		filepath.Join(root, "tn", "bob", "Phone", "sub", "2016_11_06_11_29_18_b.jpg.jpg"),
+		filepath.Join(root, "tn", "bob", "Phone", "notes.txt"),
+		// Source path does not exist so this must not be touched
+		filepath.Join(root, "tn", "bob", "Unmounted", "2016_11_06_11_29_18_c.jpg.jpg"),
+	}
+	orphans := []string{
+		filepath.Join(root, "tn", "bob", "Phone", "2016_11_06_11_29_18_gone.jpg.jpg"),
+		filepath.Join(root, "tn", "bob", "Phone", "sub", "2016_11_06_11_29_18_a.jpg.jpg"),
+	}
+	for _, f := range append(append([]string{}, keep...), orphans...) {
+		touchFile(t, f)
+	}
+
+	counts := NewDict(newTestConfig(root)).Prune(true, logTest)
+	AssertEquals(t, counts["bob"].String(), "Checked:4 Orphaned:2 Deleted:0 Failed:0")
+	for _, f := range orphans {
+		if _, err := os.Stat(f); err != nil {
+			t.Fatalf("Dry run should not delete %s", f)
+		}
+	}
+
+	counts = NewDict(newTestConfig(root)).Prune(false, logTest)
+	AssertEquals(t, counts["bob"].String(), "Checked:4 Orphaned:2 Deleted:2 Failed:0")
+	for _, f := range orphans {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Fatalf("Orphan should be deleted %s", f)
+		}
+	}
+	for _, f := range keep {
+		if _, err := os.Stat(f); err != nil {
+			t.Fatalf("File should not be deleted %s", f)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	goimage "image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const RendererScript = "script"
+const RendererNative = "native"
+
+// EXIF thumbnails are small (usually 160x120). A larger size in the jpeg header is corrupt and is not decoded.
+const embeddedThumbnailMaxPixels = 1024 * 1024
+
+// RAW previews are up to the size of the sensor. Larger is corrupt, or too big to decode in each worker.
+const embeddedPreviewMaxPixels = 64 * 1000 * 1000
+
+/*
+Read the EXIF Orientation (1..8) for the image.
+
+	Returns 1 (normal) if the image has no EXIF data or the tag is missing.
+*/
+func ImageOrientation(imagePath string, logLineFunc func(string, string)) int {
+	orientation := 0
+	NewImage(imagePath, false, func(i *IFDEntry, w *Walker) bool {
+		if i != nil && orientation == 0 && i.TagData.Name == "Orientation" {
+			o := int(i.Uint())
+			if o >= 1 && o <= 8 {
+				orientation = o
+			}
+			return true
+		}
+		return false
+	}, logLineFunc)
+	if orientation == 0 {
+		return 1
+	}
+	return orientation
+}
+
+/*
+Decode inFile, scale it to fit within a size x size box, apply the EXIF orientation and write it to outFile.
+
+	The output format is derived from the outFile extension (.jpg, .jpeg or .png).
+	The image is written to a temporary file and renamed so a failed render never leaves a partial thumbnail.
+	Images smaller than the box are not enlarged.
+*/
+func RenderThumbNail(inFile, outFile string, size int, quality int, orientation int) error {
+	fil, err := os.Open(inFile)
+	if err != nil {
+		return err
+	}
+	src, _, err := goimage.Decode(fil)
+	fil.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode image %s: %s", inFile, err.Error())
+	}
+	return writeThumbNail(orientImage(scaleToFit(src, size), orientation), outFile, quality)
+}
+
+/*
+Write the EXIF thumbnail (IFD1) of inFile to outFile if its longest side is at least minSize pixels.
+
+	Returns false, with no error, if there is no embedded thumbnail, it is too small or it is larger than
+	embeddedThumbnailMaxPixels. Use RenderThumbNail instead.
+	The thumbnail is scaled to size and the EXIF orientation of inFile is applied. If neither is needed
+	and outFile is a jpeg the thumbnail bytes are written as they are, without decoding them.
+*/
+func RenderEmbeddedThumbNail(inFile, outFile string, size, minSize, quality int, logLineFunc func(string, string)) (bool, error) {
+	im, orientation, err := newEmbeddedJpegImage(inFile, logLineFunc)
+	if err != nil || im == nil {
+		return false, nil
+	}
+	thumbnail, err := im.EmbeddedThumbnail()
+	if err != nil || thumbnail == nil {
+		return false, err
+	}
+	b, err := im.EmbeddedJpegBytes(thumbnail)
+	if err != nil {
+		return false, err
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return false, fmt.Errorf("failed to decode embedded thumbnail %s in %s: %s", thumbnail.String(), inFile, err.Error())
+	}
+	if max(cfg.Width, cfg.Height) < minSize || cfg.Width*cfg.Height > embeddedThumbnailMaxPixels {
+		return false, nil
+	}
+	ext := strings.ToLower(filepath.Ext(outFile))
+	if orientation <= 1 && max(cfg.Width, cfg.Height) <= size && (ext == ".jpg" || ext == ".jpeg") {
+		return true, writeFileAtomic(outFile, func(out *os.File) error {
+			_, err := out.Write(b)
+			return err
+		})
+	}
+	src, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		return false, fmt.Errorf("failed to decode embedded thumbnail %s in %s: %s", thumbnail.String(), inFile, err.Error())
+	}
+	return true, writeThumbNail(orientImage(scaleToFit(src, size), orientation), outFile, quality)
+}
+
+/*
+Write a thumbnail of a TIFF based RAW file (DNG, CR2, NEF...) to outFile from its largest embedded preview.
+
+	Returns false, with no error, if inFile is not TIFF based or has no preview that can be decoded. The RAW
+	data itself is never decoded. The preview is scaled to size and the EXIF orientation of inFile is applied.
+	Previews over embeddedPreviewMaxPixels are skipped so the next largest is used.
+*/
+func RenderLargestPreview(inFile, outFile string, size, quality int, logLineFunc func(string, string)) (bool, error) {
+	im, orientation, err := newEmbeddedJpegImage(inFile, logLineFunc)
+	if err != nil || im == nil || im.FileType() != ImageTypeTiff {
+		return false, nil
+	}
+	previews, err := im.EmbeddedPreviews()
+	usable := []*EmbeddedJpeg{}
+	for _, e := range previews {
+		if e.Width*e.Height <= embeddedPreviewMaxPixels {
+			usable = append(usable, e)
+		}
+	}
+	preview := LargestPreview(usable)
+	if preview == nil {
+		return false, err
+	}
+	b, err := im.EmbeddedJpegBytes(preview)
+	if err != nil {
+		return false, err
+	}
+	src, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		return false, fmt.Errorf("failed to decode embedded preview %s in %s: %s", preview.String(), inFile, err.Error())
+	}
+	return true, writeThumbNail(orientImage(scaleToFit(src, size), orientation), outFile, quality)
+}
+
+/*
+Read the entries needed to find the embedded JPEGs in inFile and the EXIF orientation of the main image.
+
+	The orientation is 0 if it is not set.
+*/
+func newEmbeddedJpegImage(inFile string, logLineFunc func(string, string)) (*image, int, error) {
+	orientation := 0
+	im, err := NewImage(inFile, false, func(i *IFDEntry, w *Walker) bool {
+		if i != nil && orientation == 0 && i.TagData.Name == "Orientation" && i.IFDPath == "Main IFD" {
+			o := int(i.Uint())
+			if o >= 1 && o <= 8 {
+				orientation = o
+			}
+			return true
+		}
+		return i != nil && IsEmbeddedJpegEntry(i)
+	}, logLineFunc)
+	return im, orientation, err
+}
+
+func writeThumbNail(img goimage.Image, outFile string, quality int) error {
+	return writeFileAtomic(outFile, func(out *os.File) error {
+		switch strings.ToLower(filepath.Ext(outFile)) {
+		case ".jpg", ".jpeg":
+			return jpeg.Encode(out, img, &jpeg.Options{Quality: quality})
+		case ".png":
+			return png.Encode(out, img)
+		}
+		return fmt.Errorf("unsupported thumbnail file type '%s'. Use .jpg, .jpeg or .png", filepath.Ext(outFile))
+	})
+}
+
+/*
+Create the directory for outFile then write it with write. The data is written to a temporary file
+and renamed so a failure never leaves a partial file.
+*/
+func writeFileAtomic(outFile string, write func(*os.File) error) error {
+	err := os.MkdirAll(filepath.Dir(outFile), 0775)
+	if err != nil {
+		return err
+	}
+	tmpFile := outFile + ".tmp"
+	out, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	err = write(out)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return os.Rename(tmpFile, outFile)
+}
+
+/*
+Scale down using a box filter. Each destination pixel is the average of the source pixels it covers.
+
+	The source is sampled where it is. It is not copied first, as a 48MP image as RGBA is about 190MB
+	for each worker.
+*/
+func scaleToFit(src goimage.Image, size int) *goimage.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := sw, sh
+	if sw > size || sh > size {
+		if sw >= sh {
+			dw = size
+			dh = max(1, (sh*size+sw/2)/sw)
+		} else {
+			dh = size
+			dw = max(1, (sw*size+sh/2)/sh)
+		}
+	}
+
+	pixel := rgbaSampler(src)
+	dst := goimage.NewRGBA(goimage.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		y0 := dy * sh / dh
+		y1 := max(y0+1, (dy+1)*sh/dh)
+		for dx := 0; dx < dw; dx++ {
+			x0 := dx * sw / dw
+			x1 := max(x0+1, (dx+1)*sw/dw)
+			var r, g, bl, a, n uint32
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					pr, pg, pb, pa := pixel(b.Min.X+x, b.Min.Y+y)
+					r += pr
+					g += pg
+					bl += pb
+					a += pa
+					n++
+				}
+			}
+			q := dst.PixOffset(dx, dy)
+			dst.Pix[q] = uint8(r / n)
+			dst.Pix[q+1] = uint8(g / n)
+			dst.Pix[q+2] = uint8(bl / n)
+			dst.Pix[q+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+/*
+A function that returns the 8 bit alpha premultiplied RGBA of the source pixel at x, y.
+
+	The types the decoders usually return (YCbCr from jpeg, RGBA) are read directly. Others use At.
+*/
+func rgbaSampler(src goimage.Image) func(x, y int) (uint32, uint32, uint32, uint32) {
+	switch s := src.(type) {
+	case *goimage.RGBA:
+		return func(x, y int) (uint32, uint32, uint32, uint32) {
+			c := s.RGBAAt(x, y)
+			return uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A)
+		}
+	case *goimage.YCbCr:
+		return func(x, y int) (uint32, uint32, uint32, uint32) {
+			c := s.YCbCrAt(x, y)
+			r, g, b := color.YCbCrToRGB(c.Y, c.Cb, c.Cr)
+			return uint32(r), uint32(g), uint32(b), 0xFF
+		}
+	case *goimage.Gray:
+		return func(x, y int) (uint32, uint32, uint32, uint32) {
+			v := uint32(s.GrayAt(x, y).Y)
+			return v, v, v, 0xFF
+		}
+	}
+	return func(x, y int) (uint32, uint32, uint32, uint32) {
+		r, g, b, a := src.At(x, y).RGBA()
+		return r >> 8, g >> 8, b >> 8, a >> 8
+	}
+}
+
+/*
+Apply an EXIF orientation so the image displays the right way up.
+
+	1 Normal            2 Flip horizontal   3 Rotate 180    4 Flip vertical
+	5 Transpose         6 Rotate 90 CW      7 Transverse    8 Rotate 90 CCW
+*/
+func orientImage(src *goimage.RGBA, orientation int) *goimage.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := goimage.NewRGBA(goimage.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var tx, ty int
+			switch orientation {
+			case 2:
+				tx, ty = w-1-x, y
+			case 3:
+				tx, ty = w-1-x, h-1-y
+			case 4:
+				tx, ty = x, h-1-y
+			case 5:
+				tx, ty = y, x
+			case 6:
+				tx, ty = h-1-y, x
+			case 7:
+				tx, ty = h-1-y, w-1-x
+			case 8:
+				tx, ty = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(tx, ty):dst.PixOffset(tx, ty)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	goimage "image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScaleToFit(t *testing.T) {
+	src := goimage.NewRGBA(goimage.Rect(0, 0, 400, 100))
+	dst := scaleToFit(src, 200)
+	if dst.Bounds().Dx() != 200 || dst.Bounds().Dy() != 50 {
+		t.Fatalf("Scaled size should be 200x50 actual %v", dst.Bounds())
+	}
+	dst = scaleToFit(src, 500)
+	if dst.Bounds().Dx() != 400 || dst.Bounds().Dy() != 100 {
+		t.Fatalf("Small images should not be enlarged actual %v", dst.Bounds())
+	}
+}
+
+func TestScaleToFitSourceTypes(t *testing.T) {
+	// Each source type is sampled in place. Same colours, different pixel layouts and bounds.
+	rect := goimage.Rect(10, 20, 410, 120)
+	rgba := goimage.NewRGBA(rect)
+	nrgba := goimage.NewNRGBA(rect)
+	gray := goimage.NewGray(rect)
+	ycbcr := goimage.NewYCbCr(rect, goimage.YCbCrSubsampleRatio444)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			v := uint8(0)
+			if x >= rect.Min.X+200 {
+				v = 0xFF
+			}
+			rgba.Set(x, y, color.Gray{Y: v})
+			nrgba.Set(x, y, color.Gray{Y: v})
+			gray.Set(x, y, color.Gray{Y: v})
+			ycbcr.Y[ycbcr.YOffset(x, y)] = v
+			ycbcr.Cb[ycbcr.COffset(x, y)] = 0x80
+			ycbcr.Cr[ycbcr.COffset(x, y)] = 0x80
+		}
+	}
+	for name, src := range map[string]goimage.Image{"RGBA": rgba, "NRGBA": nrgba, "Gray": gray, "YCbCr": ycbcr} {
+		dst := scaleToFit(src, 200)
+		AssertEquals(t, fmt.Sprintf("%s %v", name, dst.Bounds()), fmt.Sprintf("%s (0,0)-(200,50)", name))
+		AssertEquals(t, fmt.Sprintf("%s %v", name, dst.RGBAAt(50, 25)), fmt.Sprintf("%s {0 0 0 255}", name))
+		AssertEquals(t, fmt.Sprintf("%s %v", name, dst.RGBAAt(150, 25)), fmt.Sprintf("%s {255 255 255 255}", name))
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	// 3x2 image with a red pixel at the top left
+	src := goimage.NewRGBA(goimage.Rect(0, 0, 3, 2))
+	red := color.RGBA{R: 255, A: 255}
+	src.Set(0, 0, red)
+	expected := map[int][]int{
+		1: {3, 2, 0, 0},
+		2: {3, 2, 2, 0},
+		3: {3, 2, 2, 1},
+		4: {3, 2, 0, 1},
+		5: {2, 3, 0, 0},
+		6: {2, 3, 1, 0},
+		7: {2, 3, 1, 2},
+		8: {2, 3, 0, 2},
+	}
+	for o, e := range expected {
+		dst := orientImage(src, o)
+		if dst.Bounds().Dx() != e[0] || dst.Bounds().Dy() != e[1] {
+			t.Fatalf("Orientation %d size should be %dx%d actual %v", o, e[0], e[1], dst.Bounds())
+		}
+		if dst.RGBAAt(e[2], e[3]) != red {
+			t.Fatalf("Orientation %d red pixel should be at %d,%d", o, e[2], e[3])
+		}
+	}
+}
+
+func TestRenderThumbNail(t *testing.T) {
+	outFile := filepath.Join(t.TempDir(), "sub", "tn.jpg")
+	orientation := ImageOrientation("testdata/test_data_01.ti", logTest)
+	if orientation != 6 {
+		t.Fatalf("Orientation should be 6 actual %d", orientation)
+	}
+	err := RenderThumbNail("testdata/test_data_01.ti", outFile, 200, 85, orientation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fil, err := os.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fil.Close()
+	cfg, err := jpeg.DecodeConfig(fil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Source is 4160x2340 landscape rotated 90
+	if cfg.Width != 113 || cfg.Height != 200 {
+		t.Fatalf("Thumbnail should be 113x200 actual %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+/*
+A jpeg whose EXIF has orientation in IFD0 and an IFD1 thumbnail of width x height.
+*/
+func buildTestThumbnailJpeg(t *testing.T, orientation uint16, width, height int) ([]byte, []byte) {
+	var thumb bytes.Buffer
+	err := jpeg.Encode(&thumb, goimage.NewRGBA(goimage.Rect(0, 0, width, height)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifd0 := []testTiffEntry{{tag: 274, format: FormatUint16, count: 1, value: binary.BigEndian.AppendUint16(nil, orientation)}}
+	ifd1 := []testTiffEntry{
+		{tag: TagJPEGInterchangeFormat, format: FormatUint32, count: 1, value: []byte{0, 0, 0, 0}},
+		{tag: TagJPEGInterchangeFormatLength, format: FormatUint32, count: 1, value: binary.BigEndian.AppendUint32(nil, uint32(thumb.Len()))},
+	}
+	tiff := buildTestTiff(false, ifd0, ifd1)
+	// Link IFD0 to IFD1 and point IFD1 at the thumbnail after the TIFF data
+	next := 8 + 2 + len(ifd0)*TiffRecordSize
+	binary.BigEndian.PutUint32(tiff[next:], uint32(next+4))
+	binary.BigEndian.PutUint32(tiff[next+4+2+8:], uint32(len(tiff)))
+	return wrapTestExifJpeg(append(tiff, thumb.Bytes()...)), thumb.Bytes()
+}
+
+func TestRenderEmbeddedThumbNail(t *testing.T) {
+	dir := t.TempDir()
+	outFile := filepath.Join(dir, "tn", "tn.jpg")
+
+	// No rotation or scaling. The bytes are copied
+	jpg, thumb := buildTestThumbnailJpeg(t, 1, 160, 120)
+	createDataFile(t, jpg, filepath.Join(dir, "a.jpg"))
+	im, err := NewImage(filepath.Join(dir, "a.jpg"), false, nil, logTest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := im.EmbeddedThumbnail()
+	if err != nil || e == nil {
+		t.Fatalf("Thumbnail should be found. Actual %v %v", e, err)
+	}
+	AssertEquals(t, e.String(), fmt.Sprintf("Dir1 IFD@%d:%d", len(jpg)-2-len(thumb), len(thumb)))
+	done, err := RenderEmbeddedThumbNail(filepath.Join(dir, "a.jpg"), outFile, 200, 160, 85, logTest)
+	if !done || err != nil {
+		t.Fatalf("Thumbnail should be used. Actual %t %v", done, err)
+	}
+	written, _ := os.ReadFile(outFile)
+	if !bytes.Equal(written, thumb) {
+		t.Fatal("Thumbnail bytes should be written as they are")
+	}
+
+	// Too small
+	done, err = RenderEmbeddedThumbNail(filepath.Join(dir, "a.jpg"), outFile, 200, 161, 85, logTest)
+	if done || err != nil {
+		t.Fatalf("Thumbnail is too small. Actual %t %v", done, err)
+	}
+
+	// A jpeg header claiming a size no EXIF thumbnail has is not decoded
+	jpg, thumb = buildTestThumbnailJpeg(t, 1, 160, 120)
+	sof := bytes.Index(jpg, []byte{0xFF, 0xC0})
+	binary.BigEndian.PutUint16(jpg[sof+5:], 4096)  // height
+	binary.BigEndian.PutUint16(jpg[sof+7:], 65535) // width
+	createDataFile(t, jpg, filepath.Join(dir, "huge.jpg"))
+	done, err = RenderEmbeddedThumbNail(filepath.Join(dir, "huge.jpg"), filepath.Join(dir, "tn", "huge.png"), 200, 0, 85, logTest)
+	if done || err != nil {
+		t.Fatalf("A thumbnail over the pixel limit should not be used. Actual %t %v", done, err)
+	}
+
+	// No EXIF thumbnail
+	createDataFile(t, buildTestExifJpeg(false, []testTiffEntry{{tag: 274, format: FormatUint16, count: 1, value: []byte{0, 6}}}), filepath.Join(dir, "b.jpg"))
+	done, err = RenderEmbeddedThumbNail(filepath.Join(dir, "b.jpg"), outFile, 200, 0, 85, logTest)
+	if done || err != nil {
+		t.Fatalf("There is no thumbnail. Actual %t %v", done, err)
+	}
+
+	// Rotated and scaled
+	jpg, _ = buildTestThumbnailJpeg(t, 6, 160, 120)
+	createDataFile(t, jpg, filepath.Join(dir, "c.jpg"))
+	outFile = filepath.Join(dir, "tn", "tn.png")
+	done, err = RenderEmbeddedThumbNail(filepath.Join(dir, "c.jpg"), outFile, 80, 160, 85, logTest)
+	if !done || err != nil {
+		t.Fatalf("Thumbnail should be used. Actual %t %v", done, err)
+	}
+	fil, err := os.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fil.Close()
+	cfg, err := png.DecodeConfig(fil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 60 || cfg.Height != 80 {
+		t.Fatalf("Thumbnail should be 60x80 actual %dx%d", cfg.Width, cfg.Height)
+	}
+
+	// A real camera thumbnail
+	done, err = RenderEmbeddedThumbNail("testdata/test_data_01.ti", filepath.Join(dir, "tn", "real.jpg"), 200, 100, 85, logTest)
+	if !done || err != nil {
+		t.Fatalf("Thumbnail should be used. Actual %t %v", done, err)
+	}
+}
+
+func testJpegBytes(t *testing.T, width, height int) []byte {
+	var b bytes.Buffer
+	err := jpeg.Encode(&b, goimage.NewRGBA(goimage.Rect(0, 0, width, height)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+/*
+A little endian TIFF RAW like a CR2 with a small JPEG strip in IFD0 and SubIFDs holding a larger JPEG
+(JPEGInterchangeFormat) and the lossless JPEG raw data. The JPEGs follow the TIFF data.
+*/
+func buildTestRaw(t *testing.T, orientation uint16) ([]byte, []byte, []byte) {
+	small := testJpegBytes(t, 64, 48)
+	large := testJpegBytes(t, 320, 240)
+	// SOF3 (lossless) cannot be decoded
+	lossless := []byte{0xFF, 0xD8, 0xFF, 0xC3, 0x00, 0x0B, 0x08, 0x00, 0x10, 0x00, 0x10, 0x01, 0x01, 0x11, 0x00, 0xFF, 0xD9}
+	u16 := func(v int) []byte { return binary.LittleEndian.AppendUint16(nil, uint16(v)) }
+	u32 := func(v int) []byte { return binary.LittleEndian.AppendUint32(nil, uint32(v)) }
+	build := func(base int) []byte {
+		return buildTestTiff(true,
+			[]testTiffEntry{
+				{tag: TagCompression, format: FormatUint16, count: 1, value: u16(CompressionOldJpeg)},
+				{tag: TagStripOffsets, format: FormatUint32, count: 1, value: u32(base)},
+				{tag: 274, format: FormatUint16, count: 1, value: u16(int(orientation))},
+				{tag: TagStripByteCounts, format: FormatUint32, count: 1, value: u32(len(small))},
+				{tag: uint16(TagSubIFD0), format: FormatUint32, count: 2, subIFDs: []int{1, 2}},
+			},
+			[]testTiffEntry{
+				{tag: TagJPEGInterchangeFormat, format: FormatUint32, count: 1, value: u32(base + len(small))},
+				{tag: TagJPEGInterchangeFormatLength, format: FormatUint32, count: 1, value: u32(len(large))},
+			},
+			[]testTiffEntry{
+				{tag: TagCompression, format: FormatUint16, count: 1, value: u16(CompressionJpeg)},
+				{tag: TagStripOffsets, format: FormatUint32, count: 1, value: u32(base + len(small) + len(large))},
+				{tag: TagStripByteCounts, format: FormatUint32, count: 1, value: u32(len(lossless))},
+			},
+		)
+	}
+	raw := build(len(build(0)))
+	raw = append(raw, small...)
+	raw = append(raw, large...)
+	return append(raw, lossless...), small, large
+}
+
+func TestEmbeddedPreviews(t *testing.T) {
+	dir := t.TempDir()
+	raw, small, large := buildTestRaw(t, 6)
+	createDataFile(t, raw, filepath.Join(dir, "IMG_0001.CR2"))
+	im, err := NewImage(filepath.Join(dir, "IMG_0001.CR2"), false, nil, logTest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previews, err := im.EmbeddedPreviews()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(previews) != 2 {
+		t.Fatalf("The lossless raw data should not be a preview. Actual %v", previews)
+	}
+	base := len(raw) - len(small) - len(large) - 17
+	AssertEquals(t, previews[0].String(), fmt.Sprintf("Main IFD@%d:%d[64x48]", base, len(small)))
+	AssertEquals(t, previews[1].String(), fmt.Sprintf("Main IFD/SubIFDs[0]@%d:%d[320x240]", base+len(small), len(large)))
+	if LargestPreview(previews) != previews[1] {
+		t.Fatalf("The largest preview should be 320x240. Actual %v", LargestPreview(previews))
+	}
+	// IFD1 is the EXIF thumbnail. This file does not have one
+	thumbnail, err := im.EmbeddedThumbnail()
+	if thumbnail != nil || err != nil {
+		t.Fatalf("There is no thumbnail. Actual %v %v", thumbnail, err)
+	}
+
+	outFile := filepath.Join(dir, "tn", "tn.jpg")
+	done, err := RenderLargestPreview(filepath.Join(dir, "IMG_0001.CR2"), outFile, 200, 85, logTest)
+	if !done || err != nil {
+		t.Fatalf("The preview should be used. Actual %t %v", done, err)
+	}
+	fil, err := os.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fil.Close()
+	cfg, err := jpeg.DecodeConfig(fil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 150 || cfg.Height != 200 {
+		t.Fatalf("Thumbnail should be 150x200 actual %dx%d", cfg.Width, cfg.Height)
+	}
+
+	// A preview over the pixel limit is not decoded. The next largest is used
+	sof := len(raw) - len(large) - 17 + bytes.Index(large, []byte{0xFF, 0xC0})
+	binary.BigEndian.PutUint16(raw[sof+5:], 16000) // height
+	binary.BigEndian.PutUint16(raw[sof+7:], 16000) // width
+	createDataFile(t, raw, filepath.Join(dir, "IMG_0002.CR2"))
+	done, err = RenderLargestPreview(filepath.Join(dir, "IMG_0002.CR2"), outFile, 200, 85, logTest)
+	if !done || err != nil {
+		t.Fatalf("The small preview should be used. Actual %t %v", done, err)
+	}
+	fil, err = os.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fil.Close()
+	cfg, err = jpeg.DecodeConfig(fil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 48 || cfg.Height != 64 {
+		t.Fatalf("Thumbnail should be 48x64 from the small preview actual %dx%d", cfg.Width, cfg.Height)
+	}
+
+	// Jpeg files are not RAW files
+	jpg, _ := buildTestThumbnailJpeg(t, 1, 160, 120)
+	createDataFile(t, jpg, filepath.Join(dir, "a.jpg"))
+	done, err = RenderLargestPreview(filepath.Join(dir, "a.jpg"), outFile, 200, 85, logTest)
+	if done || err != nil {
+		t.Fatalf("Jpeg files should not use previews. Actual %t %v", done, err)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ScanIndexFileName = "scanIndex.jsonl"
+
+/*
+What we know about a source image from a previous run.
+
+	If Size and ModTime still match the file then DateTime can be used without reading the file.
+	DateTime is a FileDateTime spec (yyyymmddHHMMSS) with Src as the source code used for %?.
+	Offset (+hhmm) and SubSec (milliseconds) are left out if they are not known.
+	ThumbNail is the thumbnail file name derived from DateTime when the entry was written. It is a record
+	only. The time stamp settings may have changed since so the name is derived again when it is needed.
+	DateTiers is the date settings that DateTime was derived with (see ThumbnailInfo.DateTiers).
+*/
+type ScanIndexEntry struct {
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	ModTime   int64  `json:"mtime"`
+	DateTime  string `json:"dt"`
+	Src       int    `json:"src"`
+	Offset    string `json:"tz,omitempty"`
+	SubSec    int    `json:"ms,omitempty"`
+	ThumbNail string `json:"tn"`
+	DateTiers string `json:"tiers"`
+}
+
+func (e *ScanIndexEntry) FileDateTime() *FileDateTime {
+	dt, err := NewFileDateTimeFromSpec(e.DateTime, e.Src)
+	if err != nil {
+		return nil
+	}
+	if e.Offset != "" {
+		dt.setOffset(e.Offset)
+	}
+	dt.ms = e.SubSec
+	return dt
+}
+
+/*
+An on disk index (JSON lines) of scanned images keyed by path.
+
+	All methods are safe to call from multiple goroutines and on a nil *ScanIndex (no index configured).
+	Entries for files that were not seen during a run are dropped when the index is saved, but only
+	under the paths that were walked without an error (see Walked). An unmounted drive keeps its entries.
+*/
+type ScanIndex struct {
+	path      string
+	dateTiers string
+	entries   map[string]*ScanIndexEntry
+	seen      map[string]bool
+	walked    []string
+	discarded int
+	dirty     bool
+	mu        sync.Mutex
+}
+
+/*
+Load the index from stateDir. A missing index file is not an error, the index is just empty.
+
+	Lines that cannot be understood are ignored. They will be replaced when the files are re-scanned.
+	Entries derived with different date settings than dateTiers are discarded (see Discarded) so the
+	files are dated again with the current settings.
+*/
+func LoadScanIndex(stateDir string, dateTiers string) (*ScanIndex, error) {
+	si := &ScanIndex{
+		path:      filepath.Join(stateDir, ScanIndexFileName),
+		dateTiers: dateTiers,
+		entries:   map[string]*ScanIndexEntry{},
+		seen:      map[string]bool{},
+		dirty:     false,
+	}
+	fil, err := os.Open(si.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return si, nil
+		}
+		return si, err
+	}
+	defer fil.Close()
+	scanner := bufio.NewScanner(fil)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		e := &ScanIndexEntry{}
+		if json.Unmarshal(scanner.Bytes(), e) == nil && e.Path != "" {
+			if e.DateTiers != dateTiers {
+				si.discarded++
+				si.dirty = true
+				continue
+			}
+			si.entries[e.Path] = e
+		}
+	}
+	return si, scanner.Err()
+}
+
+/*
+The number of entries discarded by LoadScanIndex because the date settings have changed.
+*/
+func (si *ScanIndex) Discarded() int {
+	if si == nil {
+		return 0
+	}
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	return si.discarded
+}
+
+func (si *ScanIndex) Len() int {
+	if si == nil {
+		return 0
+	}
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	return len(si.entries)
+}
+
+/*
+Record that path still exists so its entry is kept when the index is saved.
+*/
+func (si *ScanIndex) Seen(path string) {
+	if si == nil {
+		return
+	}
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	si.seen[path] = true
+}
+
+/*
+Record that the whole of root was walked so entries under it that were not seen can be dropped.
+*/
+func (si *ScanIndex) Walked(root string) {
+	if si == nil {
+		return
+	}
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	si.walked = append(si.walked, filepath.Clean(root)+string(filepath.Separator))
+}
+
+/*
+Return the entry for path if the file has not changed (same size and modification time). Otherwise nil.
+*/
+func (si *ScanIndex) Lookup(path string, size int64, modTime time.Time) *ScanIndexEntry {
+	if si == nil {
+		return nil
+	}
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	si.seen[path] = true
+	e, ok := si.entries[path]
+	if !ok || e.Size != size || e.ModTime != modTime.UnixNano() {
+		return nil
+	}
+	return e
+}
+
+func (si *ScanIndex) Update(path string, size int64, modTime time.Time, dt *FileDateTime, thumbNail string) {
+	if si == nil || dt == nil {
+		return
+	}
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	si.seen[path] = true
+	si.entries[path] = &ScanIndexEntry{
+		Path:      path,
+		Size:      size,
+		ModTime:   modTime.UnixNano(),
+		DateTime:  dt.Spec(),
+		Src:       dt.src,
+		Offset:    dt.OffsetSpec(),
+		SubSec:    dt.ms,
+		ThumbNail: thumbNail,
+		DateTiers: si.dateTiers,
+	}
+	si.dirty = true
+}
+
+/*
+Write the index, sorted by path, if anything changed.
+
+	The file is written to a temporary file and renamed so an interrupted save never loses the old index.
+*/
+func (si *ScanIndex) Save() error {
+	if si == nil {
+		return nil
+	}
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	for p := range si.entries {
+		if !si.seen[p] && si.underWalked(p) {
+			delete(si.entries, p)
+			si.dirty = true
+		}
+	}
+	if !si.dirty {
+		return nil
+	}
+	keys := make([]string, 0, len(si.entries))
+	for k := range si.entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tmpFile := si.path + ".tmp"
+	fil, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fil)
+	for _, k := range keys {
+		b, err := json.Marshal(si.entries[k])
+		if err != nil {
+			fil.Close()
+			os.Remove(tmpFile)
+			return fmt.Errorf("failed to encode index entry %s: %s", k, err.Error())
+		}
+		w.Write(b)
+		w.WriteByte('\n')
+	}
+	err = w.Flush()
+	if err == nil {
+		err = fil.Close()
+	} else {
+		fil.Close()
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	err = os.Rename(tmpFile, si.path)
+	if err == nil {
+		si.dirty = false
+	}
+	return err
+}
+
+func (si *ScanIndex) underWalked(path string) bool {
+	for _, root := range si.walked {
+		if strings.HasPrefix(path, root) {
+			return true
+		}
+	}
+	return false
+}
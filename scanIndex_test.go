@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScanIndexSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	si, err := LoadScanIndex(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mt := time.Date(2024, 2, 1, 3, 4, 5, 6, time.Local)
+	dt, _ := NewFileDateTimeFromSpec("2016:11:06 11:29:18", 1)
+	si.Update("/a/b.jpg", 100, mt, dt, "2016_11_06_11_29_18_b.jpg.jpg")
+	si.Update("/a/gone.jpg", 100, mt, dt, "2016_11_06_11_29_18_gone.jpg.jpg")
+	si.Update("/unmounted/c.jpg", 100, mt, dt, "2016_11_06_11_29_18_c.jpg.jpg")
+	err = si.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	si, err = LoadScanIndex(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if si.Len() != 3 {
+		t.Fatalf("Index should have 3 entries actual %d", si.Len())
+	}
+	if si.Lookup("/a/b.jpg", 101, mt) != nil {
+		t.Fatal("Changed size should not match")
+	}
+	if si.Lookup("/a/b.jpg", 100, mt.Add(time.Second)) != nil {
+		t.Fatal("Changed mod time should not match")
+	}
+	e := si.Lookup("/a/b.jpg", 100, mt)
+	if e == nil {
+		t.Fatal("Unchanged file should match")
+	}
+	AssertEquals(t, e.FileDateTime().Format("%y_%m_%d_%H_%M_%S_%?"), "2016_11_06_11_29_18_01")
+	AssertEquals(t, e.ThumbNail, "2016_11_06_11_29_18_b.jpg.jpg")
+
+	// gone.jpg was not seen in /a so is dropped. /unmounted was not walked so c.jpg is kept
+	si.Walked("/a")
+	err = si.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	si, _ = LoadScanIndex(dir, "")
+	if si.Len() != 2 || si.Lookup("/unmounted/c.jpg", 100, mt) == nil {
+		t.Fatalf("Index should have b.jpg and c.jpg actual %d entries", si.Len())
+	}
+	// Only whole path names match. /a does not contain /ab
+	si.Update("/ab/d.jpg", 100, mt, dt, "2016_11_06_11_29_18_d.jpg.jpg")
+	si.Walked("/a/")
+	si.Save()
+	si, _ = LoadScanIndex(dir, "")
+	if si.Len() != 2 || si.Lookup("/ab/d.jpg", 100, mt) == nil {
+		t.Fatalf("Index should have c.jpg and d.jpg actual %d entries", si.Len())
+	}
+	_, err = os.Stat(filepath.Join(dir, ScanIndexFileName+".tmp"))
+	if !os.IsNotExist(err) {
+		t.Fatal("Temp index file should not exist")
+	}
+}
+
+func TestScanIndexNil(t *testing.T) {
+	var si *ScanIndex
+	si.Seen("/a")
+	si.Walked("/")
+	si.Update("/a", 1, time.Now(), NewFileDateTimeFromTime(time.Now()), "a.jpg")
+	if si.Lookup("/a", 1, time.Now()) != nil || si.Save() != nil || si.Len() != 0 {
+		t.Fatal("A nil index should do nothing")
+	}
+}
+
+func TestScanIndexOffset(t *testing.T) {
+	dir := t.TempDir()
+	si, _ := LoadScanIndex(dir, "")
+	mt := time.Date(2024, 2, 1, 3, 4, 5, 6, time.Local)
+	dt, _ := NewFileDateTimeFromSpec("2016:11:06 11:29:18", SrcDateTimeOriginal)
+	dt.setOffset("+09:00")
+	dt.setSubSec("07")
+	si.Update("/a/b.jpg", 100, mt, dt, "b.jpg")
+	noOffset, _ := NewFileDateTimeFromSpec("2016:11:06 11:29:18", SrcDateTimeOriginal)
+	si.Update("/a/c.jpg", 100, mt, noOffset, "c.jpg")
+	err := si.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	si, _ = LoadScanIndex(dir, "")
+	dt = si.Lookup("/a/b.jpg", 100, mt).FileDateTime()
+	AssertEquals(t, dt.Format("%y%m%d%H%M%S_%f%z"), "20161106112918_070+0900")
+	if _, ok := si.Lookup("/a/c.jpg", 100, mt).FileDateTime().Offset(); ok {
+		t.Fatal("The offset was not known")
+	}
+}
+
+func TestScanIndexDateTiers(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestConfig(dir)
+	si, _ := LoadScanIndex(dir, c.DateTiers())
+	mt := time.Date(2024, 2, 1, 3, 4, 5, 6, time.Local)
+	dt, _ := NewFileDateTimeFromSpec("2016:11:06 11:29:18", SrcDateTimeOriginal)
+	si.Update("/a/b.jpg", 100, mt, dt, "b.jpg")
+	err := si.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	si, _ = LoadScanIndex(dir, c.DateTiers())
+	if si.Lookup("/a/b.jpg", 100, mt) == nil || si.Discarded() != 0 {
+		t.Fatal("The date settings have not changed so the entry should be used")
+	}
+	c.IptcDates = true
+	si, _ = LoadScanIndex(dir, c.DateTiers())
+	if si.Lookup("/a/b.jpg", 100, mt) != nil || si.Discarded() != 1 {
+		t.Fatalf("The date settings have changed so the entry should be discarded. Discarded %d", si.Discarded())
+	}
+	// The discarded entries are removed when the index is saved
+	err = si.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	si, _ = LoadScanIndex(dir, "")
+	if si.Len() != 0 || si.Discarded() != 0 {
+		t.Fatalf("The index should be empty. Entries %d Discarded %d", si.Len(), si.Discarded())
+	}
+}
+
+func TestScanIndexUnmountedPath(t *testing.T) {
+	root := t.TempDir()
+	touchFile(t, filepath.Join(root, "orig", "bob", "Phone", "a.jpg"))
+	c := newTestConfig(root)
+	c.StateDir = filepath.Join(root, "state")
+	os.MkdirAll(c.StateDir, 0775)
+	si, _ := LoadScanIndex(c.StateDir, c.DateTiers())
+	mt := time.Date(2024, 2, 1, 3, 4, 5, 6, time.Local)
+	dt, _ := NewFileDateTimeFromSpec("2016:11:06 11:29:18", SrcDateTimeOriginal)
+	for _, p := range []string{"Phone/gone.jpg", "Unmounted/c.jpg"} {
+		si.Update(filepath.Join(root, "orig", "bob", p), 100, mt, dt, "c.jpg")
+	}
+	si.Save()
+
+	// Populate uses the global config
+	saved := config
+	config = c
+	defer func() { config = saved }()
+	d := NewDict(c)
+	d.Populate(NewTimedProcess("Test"), true)
+	err := d.SaveIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	si, _ = LoadScanIndex(c.StateDir, c.DateTiers())
+	if si.Len() != 1 || si.Lookup(filepath.Join(root, "orig", "bob", "Unmounted", "c.jpg"), 100, mt) == nil {
+		t.Fatalf("Only the entry under the path that was not walked should be kept. Entries %d", si.Len())
+	}
+}
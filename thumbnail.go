@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"image/jpeg"
+	"io"
+	"os"
+)
+
+const TagCompression = 259
+const TagStripOffsets = 273
+const TagStripByteCounts = 279
+const TagJPEGInterchangeFormat = 513
+const TagJPEGInterchangeFormatLength = 514
+
+// Compression values for JPEG compressed strips. RAW previews use either
+const CompressionOldJpeg = 6
+const CompressionJpeg = 7
+
+// The IFD after the main IFD holds the EXIF thumbnail
+const ThumbnailIFDPath = "Dir1 IFD"
+
+/*
+A JPEG image held inside the file. For example the EXIF thumbnail or a RAW preview.
+
+	Offset is the absolute position of the JPEG SOI in the file.
+	Width and Height are 0 until they are read from the JPEG (see EmbeddedPreviews).
+*/
+type EmbeddedJpeg struct {
+	IFDPath string
+	Offset  uint32
+	Length  uint32
+	Width   int
+	Height  int
+}
+
+func (e *EmbeddedJpeg) String() string {
+	if e.Width > 0 {
+		return fmt.Sprintf("%s@%d:%d[%dx%d]", e.IFDPath, e.Offset, e.Length, e.Width, e.Height)
+	}
+	return fmt.Sprintf("%s@%d:%d", e.IFDPath, e.Offset, e.Length)
+}
+
+/*
+True if the entry is needed to find an embedded JPEG. Use in a NewImage select callback.
+*/
+func IsEmbeddedJpegEntry(i *IFDEntry) bool {
+	switch i.TagData.TagNum {
+	case TagJPEGInterchangeFormat, TagJPEGInterchangeFormatLength, TagCompression, TagStripOffsets, TagStripByteCounts:
+		return true
+	}
+	return false
+}
+
+/*
+The entries in one IFD that can locate a JPEG
+*/
+type embeddedJpegEntries struct {
+	offset, length, compression, strips, stripCounts *IFDEntry
+}
+
+/*
+The JPEG given by the entries of one IFD. Nil if they do not give one.
+
+	JPEGInterchangeFormat and JPEGInterchangeFormatLength are used by the EXIF thumbnail and NEF previews.
+	A single JPEG compressed strip is used by CR2 and DNG previews. Images in more than one strip are not read.
+*/
+func (p *image) embeddedJpeg(ifdPath string, e *embeddedJpegEntries) (*EmbeddedJpeg, error) {
+	offset, length := e.offset, e.length
+	if offset == nil || length == nil {
+		if e.compression == nil || e.strips == nil || e.stripCounts == nil {
+			return nil, nil
+		}
+		c := e.compression.Uint()
+		if (c != CompressionOldJpeg && c != CompressionJpeg) || e.strips.itemCount != 1 || e.stripCounts.itemCount != 1 {
+			return nil, nil
+		}
+		offset, length = e.strips, e.stripCounts
+	}
+	if length.Uint() == 0 {
+		return nil, nil
+	}
+	err := p.checkOffset(offset.Uint(), length.Uint())
+	if err != nil {
+		return nil, &ExifError{IFDPath: ifdPath, Offset: offset.IFDAddress, Tag: offset.TagData.Name, Err: err}
+	}
+	return &EmbeddedJpeg{IFDPath: ifdPath, Offset: uint32(p.OffsetToAbs(offset.Uint())), Length: uint32(length.Uint())}, nil
+}
+
+/*
+The entries that can locate a JPEG by IFD path and the IFD paths in the order the IFDs were read.
+
+	The entries must have been kept by the NewImage select callback (see IsEmbeddedJpegEntry).
+*/
+func (p *image) embeddedJpegEntries() ([]string, map[string]*embeddedJpegEntries) {
+	paths := []string{}
+	entries := map[string]*embeddedJpegEntries{}
+	for _, i := range p.IFDdata {
+		e, ok := entries[i.IFDPath]
+		if !ok {
+			e = &embeddedJpegEntries{}
+			entries[i.IFDPath] = e
+			paths = append(paths, i.IFDPath)
+		}
+		switch i.TagData.TagNum {
+		case TagJPEGInterchangeFormat:
+			e.offset = i
+		case TagJPEGInterchangeFormatLength:
+			e.length = i
+		case TagCompression:
+			e.compression = i
+		case TagStripOffsets:
+			e.strips = i
+		case TagStripByteCounts:
+			e.stripCounts = i
+		}
+	}
+	return paths, entries
+}
+
+/*
+The EXIF thumbnail (IFD1). Nil if there is not one.
+*/
+func (p *image) EmbeddedThumbnail() (*EmbeddedJpeg, error) {
+	_, entries := p.embeddedJpegEntries()
+	e, ok := entries[ThumbnailIFDPath]
+	if !ok {
+		return nil, nil
+	}
+	return p.embeddedJpeg(ThumbnailIFDPath, e)
+}
+
+/*
+All the JPEGs in the file that can be decoded, with their dimensions. For example the EXIF thumbnail and the
+previews in the SubIFDs of a DNG, CR2 or NEF file.
+
+	The dimensions are read from each JPEG header. JPEG compressed image data that is not a viewable
+	JPEG (the lossless JPEG raw data in CR2 and DNG files) is left out. Debug logs why.
+	PreviewImageStart in the maker notes is not read as the maker notes are not parsed.
+	The previews that could be located are returned with the first error.
+*/
+func (p *image) EmbeddedPreviews() ([]*EmbeddedJpeg, error) {
+	// An IFD with a bad offset is skipped. The others are still returned
+	var err error
+	jpegs := []*EmbeddedJpeg{}
+	paths, entries := p.embeddedJpegEntries()
+	for _, path := range paths {
+		e, jpegErr := p.embeddedJpeg(path, entries[path])
+		if jpegErr != nil && err == nil {
+			err = jpegErr
+		}
+		if e != nil {
+			jpegs = append(jpegs, e)
+		}
+	}
+	if len(jpegs) == 0 {
+		return jpegs, err
+	}
+	fil, openErr := os.Open(p.name)
+	if openErr != nil {
+		return nil, openErr
+	}
+	defer fil.Close()
+	previews := []*EmbeddedJpeg{}
+	for _, e := range jpegs {
+		cfg, cfgErr := jpeg.DecodeConfig(io.NewSectionReader(fil, int64(e.Offset), int64(e.Length)))
+		if cfgErr != nil {
+			if p.debug {
+				p.logOutput(fmt.Sprintf("DEBUG: embedded jpeg %s not used: %s", e.String(), cfgErr.Error()), "")
+			}
+			continue
+		}
+		e.Width, e.Height = cfg.Width, cfg.Height
+		previews = append(previews, e)
+	}
+	return previews, err
+}
+
+/*
+The preview with the most pixels. Nil if previews is empty.
+*/
+func LargestPreview(previews []*EmbeddedJpeg) *EmbeddedJpeg {
+	var largest *EmbeddedJpeg
+	for _, e := range previews {
+		if largest == nil || e.Width*e.Height > largest.Width*largest.Height {
+			largest = e
+		}
+	}
+	return largest
+}
+
+/*
+Read the bytes of an embedded JPEG. Returns an error if it does not start with the JPEG SOI marker.
+
+	The file is opened again as NewImage closes it when the metadata has been read.
+*/
+func (p *image) EmbeddedJpegBytes(e *EmbeddedJpeg) ([]byte, error) {
+	fil, err := os.Open(p.name)
+	if err != nil {
+		return nil, err
+	}
+	defer fil.Close()
+	b := make([]byte, e.Length)
+	_, err = fil.ReadAt(b, int64(e.Offset))
+	if err != nil {
+		return nil, fmt.Errorf("embedded jpeg %s: %w", e.String(), err)
+	}
+	if len(b) < 2 || b[0] != 0xFF || b[1] != 0xD8 {
+		return nil, fmt.Errorf("embedded jpeg %s: marker 'FFD8' is missing", e.String())
+	}
+	return b, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -52,24 +53,20 @@ type fileCache struct {
 	files map[string]string
 }
 
-func CleanFileCache() *fileCache {
-	return &fileCache{
-		path:  "",
-		files: map[string]string{},
-		err:   nil,
-	}
-}
-
 func NewFileCache(path string, trimPre, trimPost int) *fileCache {
-	l := map[string]string{}
 	raw, err := os.ReadDir(path)
 	if err != nil {
 		return &fileCache{
 			path:  path,
-			files: l,
+			files: map[string]string{},
 			err:   err,
 		}
 	}
+	return newFileCacheFromEntries(path, raw, trimPre, trimPost)
+}
+
+func newFileCacheFromEntries(path string, raw []fs.DirEntry, trimPre, trimPost int) *fileCache {
+	l := map[string]string{}
 	for _, fi := range raw {
 		if !fi.IsDir() {
 			fn := fi.Name()
@@ -85,11 +82,92 @@ func NewFileCache(path string, trimPre, trimPost int) *fileCache {
 	}
 }
 
+/*
+Cache of thumbnail directory listings. Each directory is only read once per run
+and the listing is shared by every image that maps to that directory.
+
+	If Preload is used the whole thumbnail tree is read up front with a single walk
+	and directories that were not found are known to be empty without reading them.
+*/
+type thumbNailCache struct {
+	trimPre   int
+	trimPost  int
+	preloaded bool
+	dirs      map[string]*fileCache
+	readDirs  int // Number of directory listings done
+}
+
+func NewThumbNailCache(trimPre, trimPost int) *thumbNailCache {
+	return &thumbNailCache{
+		trimPre:   trimPre,
+		trimPost:  trimPost,
+		preloaded: false,
+		dirs:      map[string]*fileCache{},
+		readDirs:  0,
+	}
+}
+
+/*
+Read every directory under root in to the cache.
+*/
+func (tc *thumbNailCache) Preload(root string) error {
+	err := tc.preloadDir(root)
+	if err != nil {
+		return err
+	}
+	tc.preloaded = true
+	return nil
+}
+
+func (tc *thumbNailCache) preloadDir(path string) error {
+	raw, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	tc.readDirs++
+	tc.dirs[path] = newFileCacheFromEntries(path, raw, tc.trimPre, tc.trimPost)
+	for _, fi := range raw {
+		if fi.IsDir() {
+			err = tc.preloadDir(filepath.Join(path, fi.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+The listing for the thumbnail directory path. Read from disk the first time it is requested.
+*/
+func (tc *thumbNailCache) Dir(path string) *fileCache {
+	fc, ok := tc.dirs[path]
+	if ok {
+		return fc
+	}
+	if tc.preloaded {
+		fc = &fileCache{path: path, files: map[string]string{}, err: nil}
+	} else {
+		fc = NewFileCache(path, tc.trimPre, tc.trimPost)
+		tc.readDirs++
+	}
+	tc.dirs[path] = fc
+	return fc
+}
+
 func (fc *fileCache) HasFile(fileName string) bool {
 	_, ok := fc.files[fileName]
 	return ok
 }
 
+/*
+The thumbnail file name for an image file name.
+*/
+func (fc *fileCache) ThumbNail(fileName string) (string, bool) {
+	tn, ok := fc.files[fileName]
+	return tn, ok
+}
+
 type TimedProcess struct {
 	startTime int64
 	endTime   int64
@@ -134,10 +212,40 @@ func (t *TimedProcess) String() string {
 	return fmt.Sprintf("%s:(%dms) Events:%d (%dms) min:%s sec:%s ms:%d", t.desc, p, t.events-1, (p % t.events), pad2int64(s/60), pad2int64(s), ms)
 }
 
+// Where a FileDateTime came from. Shown in the thumbnail time stamp by %?
+const (
+	SrcModTime           = 0 // File modification time
+	SrcDateTimeOriginal  = 1
+	SrcDateTime          = 2
+	SrcDateTimeDigitized = 3
+	SrcFileName          = 4  // A date in the file name
+	SrcCreationTime      = 5  // PNG 'Creation Time' text
+	SrcVideoCreationTime = 6  // MP4/QuickTime creation date keys or mvhd creation time
+	SrcXmp               = 7  // XMP exif:DateTimeOriginal, photoshop:DateCreated or xmp:CreateDate
+	SrcXmpSidecar        = 8  // The same XMP properties from a sidecar file
+	SrcIptc              = 9  // IPTC DateCreated and TimeCreated
+	SrcGps               = 10 // GPSDateStamp and GPSTimeStamp (UTC) in local time
+)
+
+/*
+A wall clock date and time with where it came from.
+
+	ms is the milliseconds (0 if not known). offset is seconds east of UTC and is only set if hasOffset is true.
+	A time without an offset is taken to be in the local time zone.
+*/
 type FileDateTime struct {
 	y, m, d, hh, mm, ss, src int
+	ms                       int
+	offset                   int
+	hasOffset                bool
 }
 
+/*
+Replace the placeholders in formatString. %y %m %d %H %M %S are the date and time, %? the source,
+%f the milliseconds (3 digits) and %z the UTC offset (+hhmm). The placeholders are fixed width except %y.
+
+	If the offset is not known %z is the offset of the local time zone at that time.
+*/
 func (dt *FileDateTime) Format(formatString string) string {
 	s := strings.ReplaceAll(formatString, "%y", strconv.Itoa(dt.y))
 	s = strings.ReplaceAll(s, "%m", pad2(dt.m))
@@ -146,9 +254,127 @@ func (dt *FileDateTime) Format(formatString string) string {
 	s = strings.ReplaceAll(s, "%M", pad2(dt.mm))
 	s = strings.ReplaceAll(s, "%S", pad2(dt.ss))
 	s = strings.ReplaceAll(s, "%?", pad2(dt.src))
+	s = strings.ReplaceAll(s, "%f", fmt.Sprintf("%03d", dt.ms))
+	if strings.Contains(s, "%z") {
+		_, offset := dt.Time().Zone()
+		s = strings.ReplaceAll(s, "%z", formatUtcOffset(offset))
+	}
 	return s
 }
 
+/*
+The UTC offset in seconds. False if it is not known.
+*/
+func (dt *FileDateTime) Offset() (int, bool) {
+	return dt.offset, dt.hasOffset
+}
+
+/*
+The UTC offset as +hhmm. Empty if it is not known.
+*/
+func (dt *FileDateTime) OffsetSpec() string {
+	if !dt.hasOffset {
+		return ""
+	}
+	return formatUtcOffset(dt.offset)
+}
+
+/*
+Set the UTC offset from text like +01:00, -0530 or Z. Returns false, leaving the offset unchanged,
+if the text can not be read.
+*/
+func (dt *FileDateTime) setOffset(text string) bool {
+	offset, ok := parseUtcOffset(text)
+	if ok {
+		dt.offset, dt.hasOffset = offset, true
+	}
+	return ok
+}
+
+/*
+Set the milliseconds from the digits of a decimal fraction of a second (EXIF SubSecTime). '5' is 500ms.
+*/
+func (dt *FileDateTime) setSubSec(text string) {
+	text = strings.TrimSpace(text)
+	ms := 0
+	for i := 0; i < 3; i++ {
+		ms = ms * 10
+		if i < len(text) {
+			if text[i] < '0' || text[i] > '9' {
+				return
+			}
+			ms = ms + int(text[i]-'0')
+		}
+	}
+	dt.ms = ms
+}
+
+/*
+The date and time as a time.Time. In the local time zone if the offset is not known.
+*/
+func (dt *FileDateTime) Time() time.Time {
+	loc := time.Local
+	if dt.hasOffset {
+		loc = time.FixedZone("", dt.offset)
+	}
+	return time.Date(dt.y, time.Month(dt.m), dt.d, dt.hh, dt.mm, dt.ss, dt.ms*int(time.Millisecond), loc)
+}
+
+/*
+The same time in UTC. A time without an offset is taken to be local time.
+*/
+func (dt *FileDateTime) UTC() *FileDateTime {
+	utc := NewFileDateTimeFromTime(dt.Time().UTC())
+	utc.src = dt.src
+	return utc
+}
+
+func formatUtcOffset(offset int) string {
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf("%c%02d%02d", sign, offset/3600, offset%3600/60)
+}
+
+/*
+Read a UTC offset: Z, ±hh, ±hhmm or ±hh:mm. Returns seconds east of UTC.
+*/
+func parseUtcOffset(text string) (int, bool) {
+	text = strings.TrimSpace(text)
+	if text == "Z" {
+		return 0, true
+	}
+	text = strings.Replace(text, ":", "", 1)
+	if (len(text) != 3 && len(text) != 5) || (text[0] != '+' && text[0] != '-') {
+		return 0, false
+	}
+	hh, err := strconv.Atoi(text[1:3])
+	if err != nil || hh > 14 {
+		return 0, false
+	}
+	mm := 0
+	if len(text) == 5 {
+		mm, err = strconv.Atoi(text[3:5])
+		if err != nil || mm > 59 {
+			return 0, false
+		}
+	}
+	offset := hh*3600 + mm*60
+	if text[0] == '-' {
+		offset = -offset
+	}
+	return offset, true
+}
+
+/*
+The date and time as a spec that NewFileDateTimeFromSpec can read back (yyyymmddHHMMSS).
+*/
+func (dt *FileDateTime) Spec() string {
+	return fmt.Sprintf("%04d%02d%02d%02d%02d%02d", dt.y, dt.m, dt.d, dt.hh, dt.mm, dt.ss)
+}
+
 func dirExists(dir string) bool {
 	inf, err := os.Stat(dir)
 	if err != nil || inf == nil {
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const benchTnDirs = 2
+const benchTnFilesPerDir = 500
+
+/*
+Create dirs*files thumbnails and return the thumbnail root and the image names.
+*/
+func createThumbNailTree(tb testing.TB, dirs, files int) (string, []string) {
+	root := tb.TempDir()
+	names := []string{}
+	for d := 0; d < dirs; d++ {
+		dir := filepath.Join(root, "bob", fmt.Sprintf("dir%d", d))
+		err := os.MkdirAll(dir, 0775)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		for f := 0; f < files; f++ {
+			n := fmt.Sprintf("IMG_%05d.jpg", f)
+			err = os.WriteFile(filepath.Join(dir, "2016_11_06_11_29_18_"+n+".jpg"), []byte{}, 0644)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			if d == 0 {
+				names = append(names, n)
+			}
+		}
+	}
+	return root, names
+}
+
+func TestThumbNailCache(t *testing.T) {
+	root, names := createThumbNailTree(t, 2, 10)
+	tc := NewThumbNailCache(len("2016_11_06_11_29_18_"), len(".jpg"))
+	for d := 0; d < 2; d++ {
+		dir := filepath.Join(root, "bob", fmt.Sprintf("dir%d", d))
+		for _, n := range names {
+			if !tc.Dir(dir).HasFile(n) {
+				t.Fatalf("Thumbnail for %s should exist in %s", n, dir)
+			}
+		}
+		if tc.Dir(dir).HasFile("IMG_99999.jpg") {
+			t.Fatal("Thumbnail should not exist")
+		}
+	}
+	if tc.readDirs != 2 {
+		t.Fatalf("Each dir should be read once. Reads %d", tc.readDirs)
+	}
+
+	tc = NewThumbNailCache(len("2016_11_06_11_29_18_"), len(".jpg"))
+	err := tc.Preload(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// root, bob, dir0, dir1
+	if tc.readDirs != 4 {
+		t.Fatalf("Preload should read 4 dirs. Reads %d", tc.readDirs)
+	}
+	tn, ok := tc.Dir(filepath.Join(root, "bob", "dir1")).ThumbNail(names[3])
+	if !ok {
+		t.Fatal("Preloaded thumbnail should exist")
+	}
+	AssertEquals(t, tn, "2016_11_06_11_29_18_"+names[3]+".jpg")
+	if tc.Dir(filepath.Join(root, "bob", "missing")).HasFile(names[0]) || tc.readDirs != 4 {
+		t.Fatalf("Missing dir should be empty without reading it. Reads %d", tc.readDirs)
+	}
+}
+
+/*
+The old behaviour. A directory listing for every image checked.
+*/
+func BenchmarkThumbNailCheckUncached(b *testing.B) {
+	root, names := createThumbNailTree(b, benchTnDirs, benchTnFilesPerDir)
+	b.ResetTimer()
+	readDirs := 0
+	for i := 0; i < b.N; i++ {
+		for d := 0; d < benchTnDirs; d++ {
+			dir := filepath.Join(root, "bob", fmt.Sprintf("dir%d", d))
+			for _, n := range names {
+				NewFileCache(dir, 20, 4).HasFile(n)
+				readDirs++
+			}
+		}
+	}
+	b.ReportMetric(float64(readDirs)/float64(b.N), "readdirs/op")
+}
+
+func BenchmarkThumbNailCheckCached(b *testing.B) {
+	root, names := createThumbNailTree(b, benchTnDirs, benchTnFilesPerDir)
+	b.ResetTimer()
+	readDirs := 0
+	for i := 0; i < b.N; i++ {
+		tc := NewThumbNailCache(20, 4)
+		for d := 0; d < benchTnDirs; d++ {
+			dir := filepath.Join(root, "bob", fmt.Sprintf("dir%d", d))
+			for _, n := range names {
+				tc.Dir(dir).HasFile(n)
+			}
+		}
+		readDirs += tc.readDirs
+	}
+	b.ReportMetric(float64(readDirs)/float64(b.N), "readdirs/op")
+}
+
+func BenchmarkThumbNailCheckPreloaded(b *testing.B) {
+	root, names := createThumbNailTree(b, benchTnDirs, benchTnFilesPerDir)
+	b.ResetTimer()
+	readDirs := 0
+	for i := 0; i < b.N; i++ {
+		tc := NewThumbNailCache(20, 4)
+		err := tc.Preload(root)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for d := 0; d < benchTnDirs; d++ {
+			dir := filepath.Join(root, "bob", fmt.Sprintf("dir%d", d))
+			for _, n := range names {
+				tc.Dir(dir).HasFile(n)
+			}
+		}
+		readDirs += tc.readDirs
+	}
+	b.ReportMetric(float64(readDirs)/float64(b.N), "readdirs/op")
+}
+
+func TestFileDateTimeOffset(t *testing.T) {
+	dt, _ := NewFileDateTimeFromSpec("2016:11:06 01:29:18", SrcDateTimeOriginal)
+	if _, ok := dt.Offset(); ok || dt.OffsetSpec() != "" {
+		t.Fatal("A spec has no offset")
+	}
+	// Without an offset the local zone is used
+	_, local := time.Date(2016, 11, 6, 1, 29, 18, 0, time.Local).Zone()
+	AssertEquals(t, dt.Format("%z"), formatUtcOffset(local))
+
+	dt.setSubSec("5")
+	if !dt.setOffset("-05:30") {
+		t.Fatal("Offset should be read")
+	}
+	AssertEquals(t, dt.Format("%y%m%d_%H%M%S_%f%z_%?"), "20161106_012918_500-0530_01")
+	AssertEquals(t, dt.UTC().Format("%y%m%d_%H%M%S_%f%z_%?"), "20161106_065918_500+0000_01")
+	AssertEquals(t, dt.UTC().Spec(), "20161106065918")
+
+	// Bad values are ignored
+	dt.setSubSec("1x")
+	for _, text := range []string{"", "0100", "+1", "+15:00", "+01:60", "+01:00:00"} {
+		if dt.setOffset(text) {
+			t.Fatalf("Offset '%s' should not be read", text)
+		}
+	}
+	AssertEquals(t, dt.Format("%f%z"), "500-0530")
+	dt.setSubSec("1234")
+	dt.setOffset("Z")
+	AssertEquals(t, dt.Format("%f%z"), "123+0000")
+
+	// Times keep their offset and milliseconds. Dates parsed without a zone do not have an offset
+	dt = NewFileDateTimeFromTime(time.Date(2020, 1, 2, 3, 4, 5, 678900000, time.FixedZone("", 3600)))
+	AssertEquals(t, dt.Format("%H%M%S%f%z"), "030405678+0100")
+	dt = parseCreationTime("2020-01-02T03:04:05", SrcXmp)
+	if _, ok := dt.Offset(); ok {
+		t.Fatal("No offset was given")
+	}
+	AssertEquals(t, parseCreationTime("2020-01-02T03:04:05.25-08:00", SrcXmp).Format("%H%M%S%f%z"), "030405250-0800")
+
+	c := &ThumbnailInfo{ThumbNailTimeStamp: "%y_%m_%d_%H_%M_%S_%f_", UtcTimeStamps: true}
+	AssertEquals(t, c.TimeStamp(NewFileDateTimeFromTime(time.Date(2020, 1, 2, 0, 4, 5, 678900000, time.FixedZone("", 3600)))), "2020_01_01_23_04_05_678_")
+	AssertEquals(t, c.TimeStamp(parseCreationTime("2020-01-02T03:04:05.25-08:00", SrcXmp)), "2020_01_02_11_04_05_250_")
+}
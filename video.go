@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// The boxes a MP4 or QuickTime file can start with. Files with a HEIF ftyp brand are found first.
+var videoFirstBoxes = map[string]bool{
+	"ftyp": true,
+	"moov": true,
+	"mdat": true,
+	"wide": true,
+	"free": true,
+	"skip": true,
+	"pnot": true,
+}
+
+// Metadata keys that hold the time the video was recorded. The first that can be read is used.
+var videoCreationTimeKeys = []string{"com.apple.quicktime.creationdate", "©day"}
+
+// Seconds from the mvhd epoch (1904-01-01 UTC) to the unix epoch
+const mvhdEpochOffset = 2082844800
+
+// The 'data' box type indicator for UTF-8 text
+const videoDataTypeUTF8 = 1
+
+// Longest metadata text we keep. Dates and names are short.
+const videoMaxTextLen = 1024
+
+/*
+True if the file starts with a box that a MP4, MOV or 3GP file can start with.
+*/
+func isVideoFile(walker *Walker) bool {
+	w := walker.Clone()
+	boxType := string(w.Pos(4).Bytes(4))
+	return videoFirstBoxes[boxType] && w.Err() == nil
+}
+
+/*
+A top level box of a video file. Offsets are 64 bit as phone videos are often over 4GB.
+*/
+type videoBox struct {
+	Type      string
+	Offset    int64
+	HeaderLen int64
+	Size      int64
+}
+
+func (b *videoBox) String() string {
+	return fmt.Sprintf("%s@%d:%d", b.Type, b.Offset, b.Size)
+}
+
+/*
+Read the top level boxes of a video file of fileSize bytes.
+
+	A size of 1 means the 64 bit largesize follows the type. A size of 0 means the box runs to the end of the file.
+	The boxes read before any error are always returned.
+*/
+func readVideoBoxes(r io.ReaderAt, fileSize int64) ([]*videoBox, error) {
+	boxes := []*videoBox{}
+	header := make([]byte, 16)
+	for pos := int64(0); pos < fileSize; {
+		if pos+8 > fileSize {
+			return boxes, fmt.Errorf("iso box at offset %d: header runs past the end of the file (%d)", pos, fileSize)
+		}
+		// ReadAt returns an error if it reads less than asked for. A box at the end of the file can be 8 bytes.
+		n, err := r.ReadAt(header, pos)
+		if n < 8 {
+			return boxes, fmt.Errorf("iso box at offset %d: %w", pos, err)
+		}
+		box := &videoBox{Type: string(header[4:8]), Offset: pos, HeaderLen: 8}
+		size := int64(binary.BigEndian.Uint32(header))
+		switch size {
+		case 0:
+			size = fileSize - pos
+		case 1:
+			if n < 16 {
+				return boxes, fmt.Errorf("iso box '%s' at offset %d: largesize is past the end of the file (%d)", box.Type, pos, fileSize)
+			}
+			// A size over 2^63 is negative and rejected below
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			box.HeaderLen = 16
+		}
+		if size < box.HeaderLen || size > fileSize-pos {
+			return boxes, fmt.Errorf("iso box '%s' at offset %d: size %d is invalid. File size %d", box.Type, pos, size, fileSize)
+		}
+		box.Size = size
+		boxes = append(boxes, box)
+		pos = pos + size
+	}
+	return boxes, nil
+}
+
+func videoBoxesString(boxes []*videoBox) string {
+	var line bytes.Buffer
+	line.WriteRune('[')
+	for i, b := range boxes {
+		line.WriteString(b.String())
+		if i < (len(boxes) - 1) {
+			line.WriteRune(',')
+		}
+	}
+	line.WriteRune(']')
+	return line.String()
+}
+
+/*
+Read the creation time and text metadata from the 'moov' box of a MP4, MOV or 3GP file.
+
+	The top level boxes are read with 64 bit offsets as the moov box is often after more than 4GB of 'mdat'.
+	The moov box is then walked on its own so offsets within it are from the start of the moov box.
+	moov/mvhd holds the creation time in seconds since 1904 (UTC).
+	moov/udta holds QuickTime '©day' text and MP4 (iTunes) 'meta' boxes.
+	moov/meta holds the QuickTime 'keys' (com.apple.quicktime.creationdate) and the 'ilst' values for them.
+	Text values are kept in texts.
+*/
+func (p *image) findVideoMetadata(file io.ReaderAt, fileSize int64) error {
+	top, err := readVideoBoxes(file, fileSize)
+	var moov *videoBox
+	for _, b := range top {
+		if b.Type == "moov" {
+			moov = b
+			break
+		}
+	}
+	if moov == nil {
+		if err != nil {
+			return fmt.Errorf("video 'moov' box is missing. Boxes%s. Error:%s", videoBoxesString(top), err.Error())
+		}
+		return fmt.Errorf("video 'moov' box is missing. Boxes%s", videoBoxesString(top))
+	}
+	if moov.Size > math.MaxUint32 {
+		return fmt.Errorf("video 'moov' box %s is over 4GB", moov.String())
+	}
+	w := NewWalkerAt(io.NewSectionReader(file, moov.Offset, moov.Size), moov.Size, ImageWindowSize)
+	w.littleE = false // ISO-BMFF is always Big Endian
+	children, err := readIsoBoxes(w, uint32(moov.HeaderLen), uint32(moov.Size))
+	if err != nil && p.debug {
+		p.logOutput(fmt.Sprintf("DEBUG: video 'moov' box: %s", err.Error()), "")
+	}
+	if p.debug {
+		p.logOutput(fmt.Sprintf("DEBUG: Boxes%s moov%s", videoBoxesString(top), isoBoxesString(children)), "")
+	}
+	for _, b := range children {
+		switch b.Type {
+		case "mvhd":
+			p.mediaCreated = readMvhdCreationTime(w, b)
+		case "meta":
+			p.readVideoMeta(w, b)
+		case "udta":
+			udta, _ := readIsoBoxes(w, b.DataOffset(), b.End())
+			for _, u := range udta {
+				if u.Type == "meta" {
+					p.readVideoMeta(w, u)
+				} else if strings.HasPrefix(u.Type, "\xa9") {
+					p.readQuickTimeText(w, u)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+/*
+The creation time in a mvhd box. Zero if it is not set.
+*/
+func readMvhdCreationTime(w *Walker, mvhd *IsoBox) time.Time {
+	// FullBox. Version 1 has 64 bit times
+	version := w.Pos(mvhd.DataOffset()).Advance(4)
+	var seconds uint64
+	if version == 1 {
+		seconds = w.BytesToUint(w.Bytes(8))
+	} else {
+		seconds = w.BytesToUint(w.Bytes(4))
+	}
+	if w.Err() != nil || seconds <= mvhdEpochOffset {
+		return time.Time{}
+	}
+	return time.Unix(int64(seconds-mvhdEpochOffset), 0).UTC()
+}
+
+/*
+A QuickTime user data text box ('©day', '©nam'...). A 2 byte length, a 2 byte language code then the text.
+*/
+func (p *image) readQuickTimeText(w *Walker, box *IsoBox) {
+	length := uint32(w.Pos(box.DataOffset()).BytesToUint(w.Bytes(2)))
+	w.Bytes(2) // language
+	if length == 0 || length > videoMaxTextLen || box.DataOffset()+4+length > box.End() {
+		return
+	}
+	text := string(w.Bytes(length))
+	if w.Err() == nil {
+		p.texts[videoKeyName(box.Type)] = text
+	}
+}
+
+/*
+Read the text values from a 'meta' box.
+
+	The MP4 meta box is a FullBox and the QuickTime one is not. In a FullBox the first 4 bytes
+	are the version and flags (0), otherwise they are the size of the first child box.
+	In 'ilst' each item type is either a key name (like '©day') or the 1 based index of a name in 'keys'.
+*/
+func (p *image) readVideoMeta(w *Walker, meta *IsoBox) {
+	start := meta.DataOffset()
+	if w.Pos(start).BytesToUint(w.Bytes(4)) == 0 {
+		start = start + 4
+	}
+	boxes, _ := readIsoBoxes(w, start, meta.End())
+	keys := []string{}
+	if k := findIsoBox(boxes, "keys"); k != nil {
+		// FullBox then the entry count. Each entry is a size, a namespace and the name.
+		count := w.Pos(k.DataOffset() + 4).BytesToUint(w.Bytes(4))
+		pos := k.DataOffset() + 8
+		for i := uint64(0); i < count && pos+8 <= k.End(); i++ {
+			size := uint32(w.Pos(pos).BytesToUint(w.Bytes(4)))
+			w.Bytes(4) // namespace
+			// In uint64 so a corrupt size can not wrap past the end of the box
+			if size < 8 || uint64(pos)+uint64(size) > uint64(k.End()) {
+				break
+			}
+			// Keep the place of a name that is too long so the indexes of the others are still right
+			name := ""
+			if size-8 <= videoMaxTextLen {
+				name = string(w.Bytes(size - 8))
+			}
+			keys = append(keys, name)
+			pos = pos + size
+		}
+	}
+	ilst := findIsoBox(boxes, "ilst")
+	if ilst == nil {
+		return
+	}
+	items, _ := readIsoBoxes(w, ilst.DataOffset(), ilst.End())
+	for _, item := range items {
+		name := videoKeyName(item.Type)
+		index := w.Pos(item.Offset + 4).BytesToUint(w.Bytes(4))
+		if index >= 1 && index <= uint64(len(keys)) {
+			name = keys[index-1]
+		}
+		values, _ := readIsoBoxes(w, item.DataOffset(), item.End())
+		data := findIsoBox(values, "data")
+		// The type indicator and the locale come before the value
+		if data == nil || data.Size < data.HeaderLen+8 || data.Size-data.HeaderLen-8 > videoMaxTextLen {
+			continue
+		}
+		dataType := w.Pos(data.DataOffset()).BytesToUint(w.Bytes(4))
+		w.Bytes(4) // locale
+		text := string(w.Bytes(data.Size - data.HeaderLen - 8))
+		if w.Err() == nil && dataType == videoDataTypeUTF8 {
+			p.texts[name] = text
+		}
+	}
+}
+
+/*
+Box types starting with 0xA9 are Mac Roman '©'. Return them as UTF-8 so they can be used as keys.
+*/
+func videoKeyName(boxType string) string {
+	if strings.HasPrefix(boxType, "\xa9") {
+		return "©" + boxType[1:]
+	}
+	return boxType
+}
+
+/*
+The creation time from the video metadata keys or, if there are none, the mvhd box.
+
+	The keys are local time with an offset. The mvhd time is UTC so it is returned as local time.
+*/
+func (p *image) videoCreationTime() *FileDateTime {
+	for _, k := range videoCreationTimeKeys {
+		text, ok := p.texts[k]
+		if !ok {
+			continue
+		}
+		dt := parseCreationTime(text, SrcVideoCreationTime)
+		if dt != nil {
+			return dt
+		}
+	}
+	if p.mediaCreated.IsZero() {
+		return nil
+	}
+	dt := NewFileDateTimeFromTime(p.mediaCreated.Local())
+	dt.src = SrcVideoCreationTime
+	return dt
+}
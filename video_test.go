@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMvhd(version byte, created time.Time) []byte {
+	seconds := uint64(created.Unix() + mvhdEpochOffset)
+	if version == 1 {
+		b := binary.BigEndian.AppendUint64(nil, seconds)
+		b = binary.BigEndian.AppendUint64(b, seconds) // modification_time
+		return testFullBox("mvhd", 1, b, make([]byte, 88))
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(seconds))
+	b = binary.BigEndian.AppendUint32(b, uint32(seconds))
+	return testFullBox("mvhd", 0, b, make([]byte, 80))
+}
+
+func testIlstItem(itemType []byte, text string) []byte {
+	data := testIsoBox("data", binary.BigEndian.AppendUint32(nil, videoDataTypeUTF8), []byte{0, 0, 0, 0}, []byte(text))
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	b = append(b, itemType...)
+	return append(b, data...)
+}
+
+func buildTestVideo(brand string, moov ...[]byte) []byte {
+	video := testIsoBox("ftyp", []byte(brand), []byte{0, 0, 0, 0}, []byte(brand))
+	// mdat before moov as most phones write it
+	video = append(video, testIsoBox("mdat", []byte("not really a video"))...)
+	return append(video, testIsoBox("moov", moov...)...)
+}
+
+func TestVideoCreationTime(t *testing.T) {
+	created := time.Date(2018, 3, 4, 5, 6, 7, 0, time.UTC)
+	createdLocal := NewFileDateTimeFromTime(created.Local()).Spec()
+
+	// QuickTime keys for the Apple creation date
+	keyName := "com.apple.quicktime.creationdate"
+	keys := binary.BigEndian.AppendUint32(nil, 1)
+	keys = binary.BigEndian.AppendUint32(keys, uint32(8+len(keyName)))
+	keys = append(append(keys, []byte("mdta")...), []byte(keyName)...)
+	qtMeta := testIsoBox("meta",
+		testFullBox("hdlr", 0, []byte{0, 0, 0, 0}, []byte("mdta"), make([]byte, 13)),
+		testFullBox("keys", 0, keys),
+		testIsoBox("ilst", testIlstItem([]byte{0, 0, 0, 1}, "2021-06-01T12:34:56+0100")),
+	)
+	// QuickTime user data text: length, language then the text
+	qtDay := append(binary.BigEndian.AppendUint16(nil, 20), 0x15, 0xC7)
+	qtDay = append(qtDay, []byte("2020-01-01T00:00:00Z")...)
+	// MP4 (iTunes) meta is a FullBox
+	mp4Meta := testFullBox("meta", 0,
+		testFullBox("hdlr", 0, []byte{0, 0, 0, 0}, []byte("mdir"), make([]byte, 13)),
+		testIsoBox("ilst", testIlstItem([]byte("\xa9day"), "2019-05-04T16:22:51+0100")),
+	)
+
+	tests := []struct {
+		name string
+		data []byte
+		spec string
+	}{
+		{"VID_mvhd0.mp4", buildTestVideo("isom", testMvhd(0, created)), createdLocal},
+		{"VID_mvhd1.3gp", buildTestVideo("3gp4", testMvhd(1, created)), createdLocal},
+		{"VID_day.mp4", buildTestVideo("mp42", testMvhd(0, created), testIsoBox("udta", mp4Meta)), "20190504162251"},
+		{"IMG_0001.MOV", buildTestVideo("qt  ", testMvhd(0, created), testIsoBox("udta", testIsoBox("\xa9day", qtDay)), qtMeta), "20210601123456"},
+		{"IMG_0002.MOV", buildTestVideo("qt  ", testMvhd(0, created), testIsoBox("udta", testIsoBox("\xa9day", qtDay))), "20200101000000"},
+	}
+	dir := t.TempDir()
+	d := &Dict{config: newTestConfig(dir)}
+	for _, tc := range tests {
+		createDataFile(t, tc.data, filepath.Join(dir, tc.name))
+		im, err := NewImage(filepath.Join(dir, tc.name), false, nil, logTest)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		AssertEquals(t, im.FileType(), ImageTypeVideo)
+		if im.IsExif() {
+			t.Fatalf("%s: Video should not have EXIF", tc.name)
+		}
+		dt := d.GetFileDateTime(tc.name, NewGroup("", dir, ""), logTest)
+		if dt.src != SrcVideoCreationTime || dt.Spec() != tc.spec {
+			t.Fatalf("%s: Date should be %s from the video metadata. Actual %s src %d", tc.name, tc.spec, dt.Spec(), dt.src)
+		}
+		AssertEquals(t, dt.Format("%?"), "06")
+	}
+}
+
+func TestVideoNoMetadata(t *testing.T) {
+	dir := t.TempDir()
+	d := &Dict{config: newTestConfig(dir)}
+
+	// No mvhd creation time falls back to the file name
+	createDataFile(t, buildTestVideo("isom", testFullBox("mvhd", 0, make([]byte, 96))), filepath.Join(dir, "VID_20170809_101112.mp4"))
+	dt := d.GetFileDateTime("VID_20170809_101112.mp4", NewGroup("", dir, ""), logTest)
+	if dt.src != SrcFileName || dt.Spec() != "20170809101112" {
+		t.Fatalf("Date should be from the file name. Actual %s src %d", dt.Spec(), dt.src)
+	}
+
+	// No moov box
+	video := buildTestVideo("isom")
+	createDataFile(t, video[:len(video)-8], filepath.Join(dir, "nomoov.mp4"))
+	_, err := NewImage(filepath.Join(dir, "nomoov.mp4"), false, nil, logTest)
+	if err == nil || !strings.HasPrefix(err.Error(), "video 'moov' box is missing. Boxes[ftyp@0:20,mdat@20:26]") {
+		t.Fatalf("Should fail with moov missing. Actual %v", err)
+	}
+}
+
+func TestVideoOver4GB(t *testing.T) {
+	created := time.Date(2018, 3, 4, 5, 6, 7, 0, time.UTC)
+	// An mdat with a 64 bit largesize puts the moov box after 5GB. The file is sparse so it takes no space.
+	mdatSize := int64(5) << 30
+	header := testIsoBox("ftyp", []byte("isom"), []byte{0, 0, 0, 0}, []byte("isom"))
+	header = binary.BigEndian.AppendUint32(header, 1)
+	header = append(header, []byte("mdat")...)
+	header = binary.BigEndian.AppendUint64(header, uint64(mdatSize))
+	name := filepath.Join(t.TempDir(), "VID_big.mp4")
+	fil, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fil.Write(header)
+	if err == nil {
+		_, err = fil.WriteAt(testIsoBox("moov", testMvhd(0, created)), 20+mdatSize)
+	}
+	fil.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := NewImage(name, false, nil, logTest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dt := im.CreationTime()
+	if dt == nil || dt.Spec() != NewFileDateTimeFromTime(created.Local()).Spec() {
+		t.Fatalf("Date should be from the moov box after 4GB. Actual %v", dt)
+	}
+
+	// A largesize past the end of the file
+	fil, _ = os.OpenFile(name, os.O_WRONLY, 0644)
+	fil.WriteAt(binary.BigEndian.AppendUint64(nil, uint64(mdatSize)*2), 28)
+	fil.Close()
+	_, err = NewImage(name, false, nil, logTest)
+	if err == nil || !strings.Contains(err.Error(), "iso box 'mdat' at offset 20: size 10737418240 is invalid") {
+		t.Fatalf("Should fail with the mdat size. Actual %v", err)
+	}
+}
+
+func TestVideoCorruptKeys(t *testing.T) {
+	created := time.Date(2018, 3, 4, 5, 6, 7, 0, time.UTC)
+	be := binary.BigEndian
+	keyName := "com.apple.quicktime.creationdate"
+	qtMeta := func(keys []byte, index uint32) []byte {
+		return testIsoBox("meta",
+			testFullBox("hdlr", 0, []byte{0, 0, 0, 0}, []byte("mdta"), make([]byte, 13)),
+			testFullBox("keys", 0, keys),
+			testIsoBox("ilst", testIlstItem(be.AppendUint32(nil, index), "2021-06-01T12:34:56+0100")),
+		)
+	}
+	// A name longer than videoMaxTextLen keeps its index so the creation date is still found
+	long := be.AppendUint32(nil, 2)
+	long = be.AppendUint32(long, uint32(8+videoMaxTextLen+1))
+	long = append(append(long, []byte("mdta")...), make([]byte, videoMaxTextLen+1)...)
+	long = be.AppendUint32(long, uint32(8+len(keyName)))
+	long = append(append(long, []byte("mdta")...), []byte(keyName)...)
+	// A size that wraps past zero in uint32 must not be read
+	wrap := be.AppendUint32(nil, 1)
+	wrap = be.AppendUint32(wrap, 0xFFFFFFF0)
+	wrap = append(append(wrap, []byte("mdta")...), []byte(keyName)...)
+
+	tests := []struct {
+		name string
+		data []byte
+		spec string
+	}{
+		{"IMG_long.MOV", buildTestVideo("qt  ", testMvhd(0, created), qtMeta(long, 2)), "20210601123456"},
+		{"IMG_wrap.MOV", buildTestVideo("qt  ", testMvhd(0, created), qtMeta(wrap, 1)), NewFileDateTimeFromTime(created.Local()).Spec()},
+	}
+	dir := t.TempDir()
+	for _, tc := range tests {
+		createDataFile(t, tc.data, filepath.Join(dir, tc.name))
+		im, err := NewImage(filepath.Join(dir, tc.name), false, nil, logTest)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		dt := im.CreationTime()
+		if dt == nil || dt.Spec() != tc.spec {
+			t.Fatalf("%s: Date should be %s. Actual %v", tc.name, tc.spec, dt)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+/*
+A single chunk in a RIFF (WebP) file.
+
+	Offset is the position of the FourCC in the file.
+	Length is the size of the chunk data. It does NOT include the FourCC, size or pad byte.
+*/
+type RiffChunk struct {
+	FourCC string
+	Offset uint32
+	Length uint32
+}
+
+func (c *RiffChunk) DataOffset() uint32 {
+	return c.Offset + 8
+}
+
+func (c *RiffChunk) String() string {
+	return fmt.Sprintf("%s@%d:%d", c.FourCC, c.Offset, c.Length)
+}
+
+func isWebpFile(walker *Walker) bool {
+	w := walker.Clone()
+	riff := string(w.Pos(0).Bytes(4))
+	w.Bytes(4)
+	webp := string(w.Bytes(4))
+	return riff == "RIFF" && webp == "WEBP" && w.Err() == nil
+}
+
+/*
+Walk the chunks of a WebP file.
+
+	Chunks found before an error are returned with it.
+*/
+func ScanWebpChunks(walker *Walker) ([]*RiffChunk, error) {
+	chunks := []*RiffChunk{}
+	w := walker.Clone()
+	w.littleE = true // RIFF is always Little Endian
+	riffSize := w.Pos(4).BytesToUint(w.Bytes(4))
+	if w.Err() != nil {
+		return chunks, fmt.Errorf("webp chunk scan stopped: %w", w.Err())
+	}
+	// The RIFF size does not include 'RIFF' and the size itself
+	end := min(uint64(w.Len()), riffSize+8)
+	pos := uint32(12)
+	for uint64(pos)+8 <= end {
+		chunk := &RiffChunk{FourCC: string(w.Pos(pos).Bytes(4)), Offset: pos}
+		chunk.Length = uint32(w.BytesToUint(w.Bytes(4)))
+		if w.Err() != nil {
+			return chunks, fmt.Errorf("webp chunk scan stopped: %w", w.Err())
+		}
+		if uint64(chunk.DataOffset())+uint64(chunk.Length) > end {
+			return chunks, fmt.Errorf("webp chunk %s at offset %d has length %d past the end of the file", chunk.FourCC, pos, chunk.Length)
+		}
+		chunks = append(chunks, chunk)
+		// Chunks are padded to an even length
+		pos = chunk.DataOffset() + chunk.Length + (chunk.Length & 1)
+	}
+	return chunks, nil
+}
+
+/*
+Find the EXIF chunk in a WebP file and set the TIFF base and size from it.
+
+	An XMP chunk is read into xmp.
+	Returns an error wrapping ErrNoExif if there is no EXIF chunk.
+*/
+func (p *image) findWebpExif() error {
+	chunks, scanErr := ScanWebpChunks(p.walker)
+	if p.debug {
+		p.logOutput(fmt.Sprintf("DEBUG: Chunks%s", riffChunksString(chunks)), "")
+		if scanErr != nil {
+			p.logOutput(fmt.Sprintf("DEBUG: %s", scanErr.Error()), "")
+		}
+	}
+	for _, c := range chunks {
+		if c.FourCC == "XMP " {
+			w := p.walker.Clone()
+			packet := w.Pos(c.DataOffset()).Bytes(c.Length)
+			if w.Err() == nil {
+				p.readXmp(packet)
+			}
+		}
+	}
+	for _, c := range chunks {
+		if c.FourCC == "EXIF" {
+			p.tiffBase, p.tiffSize = exifPayload(p.walker, c.DataOffset(), c.Length)
+			return nil
+		}
+	}
+	if scanErr != nil {
+		return fmt.Errorf("webp %w. Chunks%s. Error:%s", ErrNoExif, riffChunksString(chunks), scanErr.Error())
+	}
+	return fmt.Errorf("webp %w. Chunks%s", ErrNoExif, riffChunksString(chunks))
+}
+
+func riffChunksString(chunks []*RiffChunk) string {
+	var line bytes.Buffer
+	line.WriteRune('[')
+	for i, c := range chunks {
+		line.WriteString(c.String())
+		if i < (len(chunks) - 1) {
+			line.WriteRune(',')
+		}
+	}
+	line.WriteRune(']')
+	return line.String()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func testRiffChunk(fourCC string, data []byte) []byte {
+	b := append([]byte(fourCC), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	b = append(b, data...)
+	if len(data)&1 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func buildTestWebp(chunks ...[]byte) []byte {
+	var body []byte
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	webp := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(4+len(body)))...)
+	webp = append(webp, []byte("WEBP")...)
+	return append(webp, body...)
+}
+
+func TestWebpExif(t *testing.T) {
+	dateTime := []byte("2022:03:04 05:06:07\x00")
+	tiff := buildTestTiff(false, []testTiffEntry{
+		{tag: 274, format: FormatUint16, count: 1, value: []byte{0, 3}},
+		{tag: 36867, format: FormatString, count: uint32(len(dateTime)), value: dateTime},
+	})
+	dir := t.TempDir()
+	// An odd length chunk first so the padding is used
+	createDataFile(t, buildTestWebp(
+		testRiffChunk("VP8X", make([]byte, 10)),
+		testRiffChunk("ICCP", make([]byte, 7)),
+		testRiffChunk("VP8 ", make([]byte, 20)),
+		testRiffChunk("EXIF", append([]byte("Exif\x00\x00"), tiff...)),
+	), filepath.Join(dir, "IMG-20220304-WA0001.webp"))
+
+	im, err := NewImage(filepath.Join(dir, "IMG-20220304-WA0001.webp"), false, nil, logTest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, im.FileType(), ImageTypeWebp)
+	AssertEquals(t, im.Output(), "DateTimeOriginal=2022:03:04 05:06:07\nOrientation=3\n")
+	d := &Dict{config: newTestConfig(dir)}
+	dt := d.GetFileDateTime("IMG-20220304-WA0001.webp", NewGroup("", dir, ""), logTest)
+	if dt.src != SrcDateTimeOriginal || dt.Spec() != "20220304050607" {
+		t.Fatalf("Date should be from DateTimeOriginal. Actual %s src %d", dt.Spec(), dt.src)
+	}
+
+	createDataFile(t, buildTestWebp(testRiffChunk("VP8 ", make([]byte, 20))), filepath.Join(dir, "noexif.webp"))
+	im, err = NewImage(filepath.Join(dir, "noexif.webp"), false, nil, logTest)
+	if !errors.Is(err, ErrNoExif) || im == nil {
+		t.Fatalf("Should return the image and ErrNoExif. Actual %v", err)
+	}
+	AssertEquals(t, err.Error(), "webp exif data is missing. Chunks[VP8 @12:20]. Path:"+filepath.Join(dir, "noexif.webp"))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+)
+
+/*
+The outcome of processing a single Data item on a worker.
+*/
+type WorkResult struct {
+	data    *Data
+	inFile  string
+	outFile string
+	exec    *ExecStatus
+	logs    []string
+	err     error
+}
+
+func NewWorkResult(data *Data) *WorkResult {
+	return &WorkResult{
+		data: data,
+		logs: []string{},
+	}
+}
+
+func (r *WorkResult) Log(s string, prefix string) {
+	if prefix != "" {
+		s = prefix + " " + s
+	}
+	r.logs = append(r.logs, s)
+}
+
+/*
+Run work for every item in list on a bounded pool of goroutines.
+
+	done is called on the calling goroutine for each result so it can update counters,
+	Data state and timers without any locking.
+	Returns when all items have been processed.
+*/
+func RunWorkerPool(workers int, list []*Data, work func(*Data) *WorkResult, done func(*WorkResult)) {
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan *Data)
+	results := make(chan *WorkResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for data := range jobs {
+				results <- work(data)
+			}
+		}()
+	}
+
+	go func() {
+		for _, data := range list {
+			jobs <- data
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	for r := range results {
+		done(r)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRunWorkerPool(t *testing.T) {
+	list := []*Data{}
+	for i := 0; i < 50; i++ {
+		list = append(list, &Data{number: i})
+	}
+	count := 0
+	RunWorkerPool(4, list, func(data *Data) *WorkResult {
+		r := NewWorkResult(data)
+		r.Log(pad4(data.number), "Worker:")
+		return r
+	}, func(r *WorkResult) {
+		count++
+		r.data.tnCreateDone = true
+		AssertEquals(t, r.logs[0], "Worker: "+pad4(r.data.number))
+	})
+	if count != len(list) {
+		t.Fatalf("Results should be %d actual %d", len(list), count)
+	}
+	for _, data := range list {
+		if data.Required() {
+			t.Fatalf("Data %d was not processed", data.number)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The APP1 identifier of the main XMP packet in a JPEG
+const JpegXmpIdent = "http://ns.adobe.com/xap/1.0/"
+
+// The PNG iTXt keyword of an XMP packet
+const PngXmpKeyword = "XML:com.adobe.xmp"
+
+// XMP namespaces we keep properties from, by the prefix they are usually written with
+var xmpNamespaces = map[string]string{
+	"http://ns.adobe.com/xap/1.0/":       "xmp",
+	"http://ns.adobe.com/xap/1.0/mm/":    "xmpMM",
+	"http://ns.adobe.com/exif/1.0/":      "exif",
+	"http://ns.adobe.com/exif/1.0/aux/":  "aux",
+	"http://ns.adobe.com/tiff/1.0/":      "tiff",
+	"http://ns.adobe.com/photoshop/1.0/": "photoshop",
+	"http://purl.org/dc/elements/1.1/":   "dc",
+}
+
+// XMP properties that hold the time the image was taken. The first that can be read is used.
+var xmpDateTimeProperties = []string{"exif:DateTimeOriginal", "photoshop:DateCreated", "xmp:CreateDate"}
+
+/*
+Read the simple properties from an XMP packet.
+
+	Properties can be attributes of rdf:Description or elements holding text.
+	Keys are 'prefix:Name' using the usual prefix for the namespace (see xmpNamespaces).
+	Properties in other namespaces and structured values (rdf:Seq, rdf:Alt...) are ignored.
+	Partial results are returned with the error.
+*/
+func ParseXmp(packet []byte) (map[string]string, error) {
+	props := map[string]string{}
+	dec := xml.NewDecoder(bytes.NewReader(packet))
+	current := "" // The property element we are in, if any
+	var text bytes.Buffer
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return props, nil
+		}
+		if err != nil {
+			return props, fmt.Errorf("xmp packet: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			for _, a := range t.Attr {
+				name := xmpPropertyName(a.Name)
+				if name != "" {
+					props[name] = strings.TrimSpace(a.Value)
+				}
+			}
+			current = xmpPropertyName(t.Name)
+			text.Reset()
+		case xml.CharData:
+			if current != "" {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+			if current != "" && current == xmpPropertyName(t.Name) && value != "" {
+				props[current] = value
+			}
+			current = ""
+			text.Reset()
+		}
+	}
+}
+
+func xmpPropertyName(name xml.Name) string {
+	prefix, ok := xmpNamespaces[name.Space]
+	if !ok {
+		return ""
+	}
+	return prefix + ":" + name.Local
+}
+
+/*
+Parse an XMP packet and add its properties to xmp. Errors are only logged in debug.
+*/
+func (p *image) readXmp(packet []byte) {
+	props, err := ParseXmp(packet)
+	if err != nil && p.debug {
+		p.logOutput(fmt.Sprintf("DEBUG: %s", err.Error()), "")
+	}
+	for k, v := range props {
+		p.xmp[k] = v
+	}
+}
+
+/*
+Read the XMP packet from the APP1 segment with the XMP identifier.
+*/
+func (p *image) readJpegXmp(segments []*JpegSegment) {
+	for _, s := range segments {
+		if !s.IsXmp() {
+			continue
+		}
+		// The identifier is zero terminated
+		identLen := uint32(len(JpegXmpIdent) + 1)
+		if s.Length <= identLen {
+			continue
+		}
+		w := p.walker.Clone()
+		packet := w.Pos(s.DataOffset() + identLen).Bytes(s.Length - identLen)
+		if w.Err() != nil {
+			if p.debug {
+				p.logOutput(fmt.Sprintf("DEBUG: xmp segment at offset %d: %s", s.Offset, w.Err().Error()), "")
+			}
+			continue
+		}
+		p.readXmp(packet)
+	}
+}
+
+/*
+The XMP properties by 'prefix:Name'. For example 'xmp:CreateDate'.
+*/
+func (p *image) Xmp() map[string]string {
+	return p.xmp
+}
+
+/*
+The date and time from the XMP properties. Nil if there is not one that can be read.
+*/
+func (p *image) XmpDateTime() *FileDateTime {
+	for _, k := range xmpDateTimeProperties {
+		text, ok := p.xmp[k]
+		if !ok {
+			continue
+		}
+		dt := parseCreationTime(text, SrcXmp)
+		if dt != nil {
+			return dt
+		}
+	}
+	return nil
+}
+
+// The extension of XMP sidecar files
+const XmpSidecarExt = ".xmp"
+
+// Largest sidecar we read. They are usually a few KB.
+const xmpMaxSidecarLen = 1024 * 1024
+
+// Where the date from an XMP sidecar comes in GetFileDateTime (ThumbnailInfo.XmpSidecars)
+const XmpSidecarAfter = "after"   // After the dates in the image. Before the file name
+const XmpSidecarBefore = "before" // Before the dates in the image
+const XmpSidecarIgnore = "ignore" // Sidecars are not read
+
+/*
+The sidecar names for an image in the order they are looked for.
+
+	For IMG_1234.CR2 these are IMG_1234.CR2.xmp then IMG_1234.xmp.
+*/
+func XmpSidecarNames(fileName string) []string {
+	names := []string{fileName + XmpSidecarExt}
+	ext := filepath.Ext(fileName)
+	if ext != "" {
+		names = append(names, fileName[:len(fileName)-len(ext)]+XmpSidecarExt)
+	}
+	return names
+}
+
+/*
+The properties read from an XMP sidecar file.
+*/
+type XmpSidecar struct {
+	Path  string
+	props map[string]string
+}
+
+/*
+Read an XMP sidecar file.
+
+	If the XMP is not valid the sidecar is returned with the properties read before the error.
+*/
+func ReadXmpSidecar(path string) (*XmpSidecar, error) {
+	fil, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fil.Close()
+	packet, err := io.ReadAll(io.LimitReader(fil, xmpMaxSidecarLen))
+	if err != nil {
+		return nil, err
+	}
+	props, err := ParseXmp(packet)
+	if err != nil {
+		return &XmpSidecar{Path: path, props: props}, fmt.Errorf("%w. Path:%s", err, path)
+	}
+	return &XmpSidecar{Path: path, props: props}, nil
+}
+
+/*
+The date and time from the sidecar properties. Nil if there is not one that can be read.
+*/
+func (s *XmpSidecar) DateTime() *FileDateTime {
+	for _, k := range xmpDateTimeProperties {
+		text, ok := s.props[k]
+		if !ok {
+			continue
+		}
+		dt := parseCreationTime(text, SrcXmpSidecar)
+		if dt != nil {
+			return dt
+		}
+	}
+	return nil
+}
+
+/*
+The xmp:Rating (0..5). Returns false if there is no rating. Rejected (-1) is returned as -1.
+*/
+func (s *XmpSidecar) Rating() (int, bool) {
+	r, err := strconv.Atoi(s.props["xmp:Rating"])
+	if err != nil || r < -1 || r > 5 {
+		return 0, false
+	}
+	return r, true
+}
+
+func (s *XmpSidecar) String() string {
+	rating := "none"
+	if r, ok := s.Rating(); ok {
+		rating = strconv.Itoa(r)
+	}
+	dt := "none"
+	if d := s.DateTime(); d != nil {
+		dt = d.Spec()
+	}
+	return fmt.Sprintf("Path:%s DateTime:%s Rating:%s", s.Path, dt, rating)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testXmpAttributes = `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/"
+    xmp:CreateDate="2015-04-05T06:07:08"
+    xmp:CreatorTool="Adobe Photoshop Lightroom Classic 12.0"
+    photoshop:DateCreated="2014-03-04T05:06:07.25+01:00"/>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+const testXmpElements = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:exif="http://ns.adobe.com/exif/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+   <exif:DateTimeOriginal>2013-02-03T04:05:06</exif:DateTimeOriginal>
+   <dc:creator><rdf:Seq><rdf:li>Someone</rdf:li></rdf:Seq></dc:creator>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+/*
+A jpeg with an APP1 XMP segment and, if tiff is not nil, an APP1 Exif segment.
+*/
+func buildTestXmpJpeg(xmp string, tiff []byte) []byte {
+	jpg := []byte{0xFF, 0xD8}
+	if tiff != nil {
+		jpg = wrapTestExifJpeg(tiff)
+		jpg = jpg[:len(jpg)-2]
+	}
+	data := append([]byte(JpegXmpIdent+"\x00"), []byte(xmp)...)
+	jpg = append(jpg, 0xFF, 0xE1)
+	jpg = binary.BigEndian.AppendUint16(jpg, uint16(2+len(data)))
+	jpg = append(jpg, data...)
+	return append(jpg, 0xFF, 0xD9)
+}
+
+func TestParseXmp(t *testing.T) {
+	props, err := ParseXmp([]byte(testXmpAttributes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, props["xmp:CreateDate"], "2015-04-05T06:07:08")
+	AssertEquals(t, props["xmp:CreatorTool"], "Adobe Photoshop Lightroom Classic 12.0")
+	AssertEquals(t, props["photoshop:DateCreated"], "2014-03-04T05:06:07.25+01:00")
+
+	props, err = ParseXmp([]byte(testXmpElements))
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, props["exif:DateTimeOriginal"], "2013-02-03T04:05:06")
+	if _, ok := props["dc:creator"]; ok || len(props) != 1 {
+		t.Fatalf("Only the simple properties should be read. Actual %v", props)
+	}
+
+	// Properties before the error are returned
+	props, err = ParseXmp([]byte(testXmpElements[:300]))
+	if err == nil || props["exif:DateTimeOriginal"] != "2013-02-03T04:05:06" {
+		t.Fatalf("Should fail with the date read. Actual %v %v", props, err)
+	}
+}
+
+func TestXmpDateTime(t *testing.T) {
+	orientation := []testTiffEntry{{tag: 274, format: FormatUint16, count: 1, value: []byte{0, 1}}}
+	dateTime := []byte("2020:01:02 03:04:05\x00")
+	tests := []struct {
+		name string
+		data []byte
+		spec string
+		src  int
+	}{
+		{"xmponly.jpg", buildTestXmpJpeg(testXmpAttributes, nil), "20140304050607", SrcXmp},
+		{"xmpexif.jpg", buildTestXmpJpeg(testXmpElements, buildTestTiff(false, orientation)), "20130203040506", SrcXmp},
+		{"exifdate.jpg", buildTestXmpJpeg(testXmpElements, buildTestTiff(false, []testTiffEntry{{tag: 306, format: FormatString, count: uint32(len(dateTime)), value: dateTime}})), "20200102030405", SrcDateTime},
+		{"xmp.png", buildTestPng(testPngChunk("iTXt", append([]byte(PngXmpKeyword+"\x00\x00\x00\x00\x00"), []byte(testXmpAttributes)...))), "20140304050607", SrcXmp},
+		{"xmp.webp", buildTestWebp(testRiffChunk("VP8 ", make([]byte, 20)), testRiffChunk("XMP ", []byte(testXmpElements))), "20130203040506", SrcXmp},
+	}
+	dir := t.TempDir()
+	d := &Dict{config: newTestConfig(dir)}
+	for _, tc := range tests {
+		createDataFile(t, tc.data, filepath.Join(dir, tc.name))
+		im, _ := NewImage(filepath.Join(dir, tc.name), false, nil, logTest)
+		if im == nil || len(im.Xmp()) == 0 {
+			t.Fatalf("%s: XMP should be read", tc.name)
+		}
+		dt := d.GetFileDateTime(tc.name, NewGroup("", dir, ""), logTest)
+		if dt.src != tc.src || dt.Spec() != tc.spec {
+			t.Fatalf("%s: Date should be %s src %d. Actual %s src %d", tc.name, tc.spec, tc.src, dt.Spec(), dt.src)
+		}
+	}
+}
+
+func testXmpSidecar(date string, rating int) string {
+	return fmt.Sprintf(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="%d" xmp:CreateDate="%s"/>
+</rdf:RDF></x:xmpmeta>`, rating, date)
+}
+
+func TestXmpSidecars(t *testing.T) {
+	root := t.TempDir()
+	phone := filepath.Join(root, "orig", "bob", "Phone")
+	if err := os.MkdirAll(phone, 0775); err != nil {
+		t.Fatal(err)
+	}
+	dateTime := []byte("2020:01:02 03:04:05\x00")
+	createDataFile(t, buildTestExifJpeg(false, []testTiffEntry{{tag: 306, format: FormatString, count: uint32(len(dateTime)), value: dateTime}}), filepath.Join(phone, "IMG_1234.CR2"))
+	createDataFile(t, []byte(testXmpSidecar("2019-08-07T06:05:04", 4)), filepath.Join(phone, "IMG_1234.CR2.xmp"))
+	createDataFile(t, []byte(testXmpSidecar("2018-01-01T00:00:00", 1)), filepath.Join(phone, "IMG_1234.xmp"))
+	createDataFile(t, buildTestPng(), filepath.Join(phone, "IMG_2000.png"))
+	createDataFile(t, []byte(testXmpSidecar("2017-02-03T04:05:06+01:00", 5)), filepath.Join(phone, "IMG_2000.XMP"))
+	createDataFile(t, buildTestPng(), filepath.Join(phone, "IMG_3000.png"))
+
+	upi := &UserPathInfo{root: filepath.Join(root, "orig"), user: "bob", iPath: "Phone"}
+	found := map[string]*Data{}
+	scanUserPath(upi, true, nil, func(d *Data) {
+		found[d.fileName] = d
+	})
+	if len(found) != 3 {
+		t.Fatalf("Sidecars should not be returned as images. Actual %v", found)
+	}
+	AssertEquals(t, found["IMG_1234.CR2"].sidecar, "IMG_1234.CR2.xmp")
+	AssertEquals(t, found["IMG_2000.png"].sidecar, "IMG_2000.XMP")
+	AssertEquals(t, found["IMG_3000.png"].sidecar, "")
+
+	count := 0
+	scanUserPath(upi, false, nil, func(d *Data) {
+		count++
+		if d.sidecar != "" {
+			t.Fatalf("Sidecars should not be found. %s", d.fileName)
+		}
+	})
+	AssertEquals(t, strconv.Itoa(count), "6")
+
+	sidecar, err := ReadXmpSidecar(filepath.Join(phone, "IMG_2000.XMP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, sidecar.String(), "Path:"+filepath.Join(phone, "IMG_2000.XMP")+" DateTime:20170203040506 Rating:5")
+
+	tests := []struct {
+		precedence string
+		fileName   string
+		spec       string
+		src        int
+	}{
+		{XmpSidecarAfter, "IMG_1234.CR2", "20200102030405", SrcDateTime},
+		{XmpSidecarAfter, "IMG_2000.png", "20170203040506", SrcXmpSidecar},
+		{XmpSidecarBefore, "IMG_1234.CR2", "20190807060504", SrcXmpSidecar},
+		{XmpSidecarIgnore, "IMG_2000.png", "", SrcModTime}, // IMG_2000 is not a date so the file time is used
+	}
+	for _, tc := range tests {
+		d := &Dict{config: newTestConfig(root), sidecars: map[string]string{}}
+		d.config.XmpSidecars = tc.precedence
+		scanUserPath(upi, tc.precedence != XmpSidecarIgnore, nil, func(data *Data) {
+			if data.sidecar != "" {
+				d.sidecars[filepath.Join(phone, data.fileName)] = filepath.Join(phone, data.sidecar)
+			}
+		})
+		dt := d.GetFileDateTime(tc.fileName, NewGroup("bob", filepath.Join(root, "orig"), "Phone"), logTest)
+		if dt.src != tc.src || (tc.spec != "" && dt.Spec() != tc.spec) {
+			t.Fatalf("%s %s: Date should be %s src %d. Actual %s src %d", tc.precedence, tc.fileName, tc.spec, tc.src, dt.Spec(), dt.src)
+		}
+	}
+}
+
+func TestXmpSidecarIndex(t *testing.T) {
+	root := t.TempDir()
+	createDataFile(t, buildTestPng(), filepath.Join(root, "IMG_2000.png"))
+	createDataFile(t, []byte(testXmpSidecar("2017-02-03T04:05:06", 0)), filepath.Join(root, "IMG_2000.png.xmp"))
+	index, err := LoadScanIndex(root, newTestConfig(root).DateTiers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Dict{config: newTestConfig(root), index: index, sidecars: map[string]string{filepath.Join(root, "IMG_2000.png"): filepath.Join(root, "IMG_2000.png.xmp")}}
+	AssertEquals(t, d.GetFileDateTime("IMG_2000.png", NewGroup("", root, ""), logTest).Spec(), "20170203040506")
+
+	// An edited sidecar is read again even though the image has not changed
+	createDataFile(t, []byte(testXmpSidecar("2016-02-03T04:05:06", 0)), filepath.Join(root, "IMG_2000.png.xmp"))
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(root, "IMG_2000.png.xmp"), later, later)
+	AssertEquals(t, d.GetFileDateTime("IMG_2000.png", NewGroup("", root, ""), logTest).Spec(), "20160203040506")
+}